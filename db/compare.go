@@ -0,0 +1,179 @@
+package db
+
+import (
+	"slices"
+)
+
+// Comparing setlists. Songs are matched up across the whole setlist for
+// what's only in one or the other, and set by set (first set against first
+// set) for what moved. A song only counts as moved if it's out of order with
+// the rest of the songs both sets share, so one song added at the top doesn't
+// make every song after it look moved. Items that aren't songs are left out.
+
+type SetlistDiff struct {
+	A, B   Setlist
+	OnlyA  []Track
+	OnlyB  []Track
+	Shared []Track
+	Sets   []SetDiff
+}
+
+type SetDiff struct {
+	SetNum  int
+	A, B    Set // either can be empty if one setlist has more sets
+	Entries []DiffEntry
+	Dropped []Track // in A's set but not B's
+}
+
+// DiffEntry is a song of B's set, Was is where it was in A's (from 1), 0 if it wasn't
+type DiffEntry struct {
+	Track Track
+	Pos   int
+	Was   int
+	Moved bool
+}
+
+func (e DiffEntry) Added() bool {
+	return e.Was == 0
+}
+
+func CompareSetlists(a, b Setlist) SetlistDiff {
+	d := SetlistDiff{A: a, B: b, OnlyA: []Track{}, OnlyB: []Track{}, Shared: []Track{}}
+	inA, inB := songs(a), songs(b)
+	for _, t := range setlistTracks(a) {
+		if inB[t.Id] {
+			d.Shared = append(d.Shared, t)
+		} else {
+			d.OnlyA = append(d.OnlyA, t)
+		}
+	}
+	for _, t := range setlistTracks(b) {
+		if !inA[t.Id] {
+			d.OnlyB = append(d.OnlyB, t)
+		}
+	}
+	for i := 0; i < max(len(a.Sets), len(b.Sets)); i++ {
+		sd := SetDiff{SetNum: i}
+		if i < len(a.Sets) {
+			sd.A = a.Sets[i]
+		}
+		if i < len(b.Sets) {
+			sd.B = b.Sets[i]
+		}
+		d.Sets = append(d.Sets, compareSets(sd))
+	}
+	return d
+}
+
+// copyOf is the nth time (from 1) a song comes up in a set, a song played
+// twice is matched first copy to first copy
+type copyOf struct {
+	id int64
+	n  int
+}
+
+func copies(tracks []Track) []copyOf {
+	seen := map[int64]int{}
+	cs := []copyOf{}
+	for _, t := range tracks {
+		seen[t.Id]++
+		cs = append(cs, copyOf{t.Id, seen[t.Id]})
+	}
+	return cs
+}
+
+func compareSets(sd SetDiff) SetDiff {
+	sd.A.Tracks, sd.B.Tracks = songsOnly(sd.A.Tracks), songsOnly(sd.B.Tracks)
+	cA, cB := copies(sd.A.Tracks), copies(sd.B.Tracks)
+	posA := map[copyOf]int{}
+	for i, c := range cA {
+		posA[c] = i + 1
+	}
+	inB := map[copyOf]bool{}
+	shared := []copyOf{}
+	for _, c := range cB {
+		inB[c] = true
+		if posA[c] != 0 {
+			shared = append(shared, c)
+		}
+	}
+	// shared songs in A's order, the ones left out of the longest run they
+	// keep in both are the ones that moved
+	sharedA := []copyOf{}
+	for _, c := range cA {
+		if inB[c] {
+			sharedA = append(sharedA, c)
+		}
+	}
+	kept := commonOrder(sharedA, shared)
+	for i, t := range sd.B.Tracks {
+		e := DiffEntry{Track: t, Pos: i + 1, Was: posA[cB[i]]}
+		e.Moved = e.Was != 0 && !kept[cB[i]]
+		sd.Entries = append(sd.Entries, e)
+	}
+	for i, t := range sd.A.Tracks {
+		if !inB[cA[i]] {
+			sd.Dropped = append(sd.Dropped, t)
+		}
+	}
+	return sd
+}
+
+// commonOrder is the songs of the longest common subsequence of a and b
+func commonOrder(a, b []copyOf) map[copyOf]bool {
+	// l[i][j] is the longest for a[i:] and b[j:]
+	l := make([][]int, len(a)+1)
+	for i := range l {
+		l[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				l[i][j] = l[i+1][j+1] + 1
+			} else {
+				l[i][j] = max(l[i+1][j], l[i][j+1])
+			}
+		}
+	}
+	kept := map[copyOf]bool{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			kept[a[i]] = true
+			i++
+			j++
+		case l[i+1][j] >= l[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return kept
+}
+
+// setlistTracks is every song in the setlist once, in running order
+func setlistTracks(sl Setlist) []Track {
+	seen := map[int64]bool{}
+	tracks := []Track{}
+	for _, s := range sl.Sets {
+		for _, t := range songsOnly(s.Tracks) {
+			if !seen[t.Id] {
+				seen[t.Id] = true
+				tracks = append(tracks, t)
+			}
+		}
+	}
+	return tracks
+}
+
+func songsOnly(tracks []Track) []Track {
+	return slices.DeleteFunc(slices.Clone(tracks), Track.IsItem)
+}
+
+func songs(sl Setlist) map[int64]bool {
+	in := map[int64]bool{}
+	for _, t := range setlistTracks(sl) {
+		in[t.Id] = true
+	}
+	return in
+}
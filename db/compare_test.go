@@ -0,0 +1,101 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// songList is songs with the given ids, 0 for an item that isn't a song
+func songList(ids ...int64) []Track {
+	tracks := []Track{}
+	for _, id := range ids {
+		t := Track{Id: id}
+		if id == 0 {
+			t.Title = "raffle"
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks
+}
+
+func TestCompareSets(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    []int64
+		entries []string // B's songs as "id:was", "id:was moved" or "id:new", on a tie the earlier song in A moved
+		dropped []int64
+	}{
+		{"same", []int64{1, 2, 3}, []int64{1, 2, 3}, []string{"1:1", "2:2", "3:3"}, nil},
+		{"one added on top", []int64{1, 2, 3}, []int64{4, 1, 2, 3}, []string{"4:new", "1:1", "2:2", "3:3"}, nil},
+		{"one dropped", []int64{1, 2, 3}, []int64{1, 3}, []string{"1:1", "3:3"}, []int64{2}},
+		{"one moved to the end", []int64{1, 2, 3, 4}, []int64{2, 3, 4, 1}, []string{"2:2", "3:3", "4:4", "1:1 moved"}, nil},
+		{"two swapped", []int64{1, 2, 3, 4}, []int64{1, 3, 2, 4}, []string{"1:1", "3:3", "2:2 moved", "4:4"}, nil},
+		{"reversed", []int64{1, 2, 3}, []int64{3, 2, 1}, []string{"3:3", "2:2 moved", "1:1 moved"}, nil},
+		{"moved past added and dropped", []int64{1, 2, 3, 4}, []int64{5, 3, 1, 4}, []string{"5:new", "3:3", "1:1 moved", "4:4"}, []int64{2}},
+		{"items left out", []int64{1, 0, 2}, []int64{0, 2, 1, 0}, []string{"2:2", "1:1 moved"}, nil},
+		{"empty A", nil, []int64{1, 2}, []string{"1:new", "2:new"}, nil},
+		{"empty B", []int64{1, 2}, nil, []string{}, []int64{1, 2}},
+
+		// a song played twice is matched copy for copy
+		{"played twice in both", []int64{1, 2, 1, 3}, []int64{1, 3, 2, 1}, []string{"1:1", "3:4 moved", "2:2", "1:3"}, nil},
+		{"second copy added", []int64{1, 2, 3}, []int64{2, 2, 1, 3}, []string{"2:2", "2:new", "1:1 moved", "3:3"}, nil},
+		{"second copy dropped", []int64{2, 1, 2, 3}, []int64{1, 2, 3}, []string{"1:2", "2:1 moved", "3:4"}, []int64{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd := compareSets(SetDiff{A: Set{Tracks: songList(tt.a...)}, B: Set{Tracks: songList(tt.b...)}})
+			got := []string{}
+			for _, e := range sd.Entries {
+				switch {
+				case e.Added():
+					got = append(got, fmt.Sprintf("%d:new", e.Track.Id))
+				case e.Moved:
+					got = append(got, fmt.Sprintf("%d:%d moved", e.Track.Id, e.Was))
+				default:
+					got = append(got, fmt.Sprintf("%d:%d", e.Track.Id, e.Was))
+				}
+			}
+			if !slices.Equal(got, tt.entries) {
+				t.Errorf("entries %q, want %q", got, tt.entries)
+			}
+			dropped := []int64{}
+			for _, d := range sd.Dropped {
+				dropped = append(dropped, d.Id)
+			}
+			if !slices.Equal(dropped, tt.dropped) {
+				t.Errorf("dropped %v, want %v", dropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestCompareSetlists(t *testing.T) {
+	a := Setlist{Sets: []Set{{Tracks: songList(1, 2, 0)}, {Tracks: songList(3, 4)}}}
+	b := Setlist{Sets: []Set{{Tracks: songList(2, 5)}, {Tracks: songList(4, 3, 1)}, {Tracks: songList(6)}}}
+	d := CompareSetlists(a, b)
+	idsOf := func(tracks []Track) []int64 {
+		ids := []int64{}
+		for _, t := range tracks {
+			ids = append(ids, t.Id)
+		}
+		return ids
+	}
+	for _, c := range []struct {
+		what      string
+		got, want []int64
+	}{
+		{"only in A", idsOf(d.OnlyA), []int64{}},
+		{"only in B", idsOf(d.OnlyB), []int64{5, 6}},
+		{"shared", idsOf(d.Shared), []int64{1, 2, 3, 4}},
+		{"set 1 dropped", idsOf(d.Sets[0].Dropped), []int64{1}},
+		{"set 3 added", idsOf(d.Sets[2].B.Tracks), []int64{6}},
+	} {
+		if !slices.Equal(c.got, c.want) {
+			t.Errorf("%s %v, want %v", c.what, c.got, c.want)
+		}
+	}
+	if len(d.Sets) != 3 {
+		t.Errorf("%d sets compared, want 3", len(d.Sets))
+	}
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"slices"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -12,13 +13,14 @@ type DB struct {
 	db *sql.DB
 }
 
+// NewDB makes a fresh db, init migrates once the base tables are there
 func NewDB(path string) (*DB, error) {
-	d, err := OpenDB(path)
+	d, err := sql.Open("sqlite", path)
 	if err != nil {
 		return nil, err
 	}
-	err = d.init()
-	return d, err
+	tdb := &DB{db: d}
+	return tdb, tdb.init()
 }
 
 func OpenDB(path string) (*DB, error) {
@@ -26,7 +28,101 @@ func OpenDB(path string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db: d}, nil
+	tdb := &DB{db: d}
+	return tdb, tdb.migrate()
+}
+
+// migrate brings an existing db file up to date with tables added since it was made
+func (d *DB) migrate() error {
+	err := d.migrateGigs()
+	if err != nil {
+		return err
+	}
+	for _, schema := range []string{gigSchema, historySchema, eventSchema, flowSchema, formatSchema} {
+		_, err = d.db.Exec(schema)
+		if err != nil {
+			return err
+		}
+	}
+	columns := []struct{ table, column, decl string }{
+		{"gig_position", "since", "INTEGER NOT NULL DEFAULT 0"},
+		{"track", "duration", "INTEGER NOT NULL DEFAULT 0"},
+		{"setlist", "gap", fmt.Sprintf("INTEGER NOT NULL DEFAULT %d", DefaultGap)},
+		{"setlist", "set_target", "INTEGER NOT NULL DEFAULT 0"},
+		{"sets_tracks", "title", "TEXT NOT NULL DEFAULT ''"},
+		{"sets_tracks", "duration", "INTEGER NOT NULL DEFAULT 0"},
+		{"sets_tracks", "notes", "TEXT NOT NULL DEFAULT ''"},
+		{"gig_entry", "title", "TEXT NOT NULL DEFAULT ''"},
+		{"gig_entry", "duration", "INTEGER NOT NULL DEFAULT 0"},
+		{"gig_entry", "notes", "TEXT NOT NULL DEFAULT ''"},
+		{"sets_tracks", "segue", "INTEGER NOT NULL DEFAULT 0"},
+		{"gig_entry", "segue", "INTEGER NOT NULL DEFAULT 0"},
+		{"a_set", "target", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		err = d.addColumn(c.table, c.column, c.decl)
+		if err != nil {
+			return err
+		}
+	}
+	return d.migrateSetEntries()
+}
+
+// migrateSetEntries gives sets_tracks made before it had one an id column.
+// Entries were told apart by their rowid, which sqlite may renumber on a
+// vacuum without a primary key to hold it, so each keeps its rowid as its
+// id. seq is numbered again too, old entries were all 0 and only in order
+// by rowid.
+func (d *DB) migrateSetEntries() error {
+	var n int
+	q := "select count(*) from pragma_table_info('sets_tracks') where name = 'id';"
+	err := d.db.QueryRow(q).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	fmt.Println("adding sets_tracks.id")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		"alter table sets_tracks rename to sets_tracks_old;",
+		`create table sets_tracks (
+			id INTEGER primary key,
+			set_id INTEGER NOT NULL,
+			track_id INTEGER NOT NULL,
+			seq INTEGER NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			duration INTEGER NOT NULL DEFAULT 0,
+			notes TEXT NOT NULL DEFAULT '',
+			segue INTEGER NOT NULL DEFAULT 0
+		);`,
+		`insert into sets_tracks (id, set_id, track_id, seq, title, duration, notes, segue)
+		select rowid, set_id, track_id, row_number() over (partition by set_id order by seq, rowid) - 1,
+			title, duration, notes, segue
+		from sets_tracks_old;`,
+		"drop table sets_tracks_old;",
+	} {
+		_, err = tx.Exec(q)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addColumn adds a column to a table made before the column was
+func (d *DB) addColumn(table, column, decl string) error {
+	var n int
+	q := "select count(*) from pragma_table_info($1) where name = $2;"
+	err := d.db.QueryRow(q, table, column).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	fmt.Printf("adding %s.%s\n", table, column)
+	_, err = d.db.Exec(fmt.Sprintf("alter table %s add column %s %s;", table, column, decl))
+	return err
 }
 
 func (d *DB) init() error {
@@ -76,25 +172,36 @@ func (d *DB) init() error {
 		timestamp INTEGER NOT NULL
 	);
 	drop table if exists a_set;
-	create table a_set {
+	create table a_set (
 		id INTEGER primary key,
-		setlist_id INTEGER NOT NULL
+		setlist_id INTEGER NOT NULL,
 		name TEXT NOT NULL,
 		setnum INTEGER NOT NULL
-	};
+	);
 	drop table if exists sets_tracks;
-	create table sets_tracks {
+	create table sets_tracks (
+		id INTEGER primary key,
 		set_id INTEGER NOT NULL,
 		track_id INTEGER NOT NULL,
 		seq INTEGER NOT NULL
-	};
-	drop table if exists gig {
-		id INTEGER primary key,
-		obj BLOB NOT NULL,
-	}
+	);
+	drop table if exists gig;
+	drop table if exists gig_set;
+	drop table if exists gig_entry;
+	drop table if exists gig_position;
+	drop table if exists gig_call;
+	drop table if exists performance;
+	drop table if exists venue;
+	drop table if exists event;
+	drop table if exists flow_rule;
+	drop table if exists format;
+	drop table if exists format_set;
+	drop table if exists format_slot;
 	`)
-
-	return err
+	if err != nil {
+		return err
+	}
+	return d.migrate()
 }
 
 func (d *DB) GetVoxByName(name string) (int64, error) {
@@ -127,7 +234,8 @@ func (d *DB) GetKitByName(name string) (int64, error) {
 
 var trackSelect string = `
 select 
-	track.id, track.title, track.tempo, track.click, track.key_tone, vox.id, vox.name, era.id, era.name, genre.id, genre.name, kit.id, kit.name 
+	track.id, track.title, track.tempo, track.click, track.key_tone, vox.id, vox.name, era.id, era.name, genre.id, genre.name, kit.id, kit.name,
+	track.duration
 from track 
 	join vox on track.vox_id = vox.id
 	join era on track.era_id = era.id
@@ -203,8 +311,9 @@ func (d *DB) extractTracks(rows *sql.Rows) ([]Track, error) {
 			genre    string
 			kit_id   int64
 			kit      string
+			duration int64
 		)
-		err := rows.Scan(&id, &title, &tempo, &click, &key_tone, &vox_id, &vox, &era_id, &era, &genre_id, &genre, &kit_id, &kit)
+		err := rows.Scan(&id, &title, &tempo, &click, &key_tone, &vox_id, &vox, &era_id, &era, &genre_id, &genre, &kit_id, &kit, &duration)
 		if err != nil {
 			return nil, err
 		}
@@ -213,15 +322,16 @@ func (d *DB) extractTracks(rows *sql.Rows) ([]Track, error) {
 		genreObj := Genre{Id: genre_id, Name: genre}
 		kitObj := Kit{Id: kit_id, Name: kit}
 		track := Track{
-			Id:      id,
-			Title:   title,
-			Tempo:   int(tempo),
-			Click:   click == 1,
-			KeyTone: key_tone,
-			Vox:     voxObj, //fmt.Sprintf("vox %d", vox_id),
-			Era:     eraObj,
-			Genre:   genreObj, //fmt.Sprintf("genre %d", genre_id),
-			Kit:     kitObj,   //fmt.Sprintf("kit %d", kit_id),
+			Id:       id,
+			Title:    title,
+			Tempo:    int(tempo),
+			Click:    click == 1,
+			KeyTone:  key_tone,
+			Vox:      voxObj, //fmt.Sprintf("vox %d", vox_id),
+			Era:      eraObj,
+			Genre:    genreObj, //fmt.Sprintf("genre %d", genre_id),
+			Kit:      kitObj,   //fmt.Sprintf("kit %d", kit_id),
+			Duration: int(duration),
 		}
 		tracks = append(tracks, track)
 	}
@@ -354,7 +464,7 @@ select
 	track.id, track.title, track.tempo, 
 	track.click, track.key_tone, vox.id, vox.name, 
 	era.id, era.name, genre.id, genre.name,
-	kit.id, kit.name, track.lyrics_id
+	kit.id, kit.name, track.lyrics_id, track.duration
 from track
 	join vox on track.vox_id = vox.id
 	join era on track.era_id = era.id
@@ -379,9 +489,10 @@ where track.id = $1;
 		lyrics_id_null sql.NullInt64
 		lyrics_id      int64
 		lyrics         string
+		duration       int64
 	)
 
-	err := row.Scan(&id, &title, &tempo, &click, &key_tone, &vox_id, &vox, &era_id, &era, &genre_id, &genre, &kit_id, &kit, &lyrics_id_null)
+	err := row.Scan(&id, &title, &tempo, &click, &key_tone, &vox_id, &vox, &era_id, &era, &genre_id, &genre, &kit_id, &kit, &lyrics_id_null, &duration)
 	if err != nil {
 		return Track{}, err
 	}
@@ -400,16 +511,17 @@ where track.id = $1;
 	kitObj := Kit{Id: kit_id, Name: kit}
 	lyricsObj := Lyrics{Id: lyrics_id, RawText: lyrics}
 	t := Track{
-		Id:      id,
-		Title:   title,
-		Tempo:   int(tempo),
-		Click:   click == 1,
-		KeyTone: key_tone,
-		Vox:     voxObj,
-		Era:     eraObj,
-		Genre:   genreObj,
-		Kit:     kitObj,
-		Lyrics:  lyricsObj,
+		Id:       id,
+		Title:    title,
+		Tempo:    int(tempo),
+		Click:    click == 1,
+		KeyTone:  key_tone,
+		Vox:      voxObj,
+		Era:      eraObj,
+		Genre:    genreObj,
+		Kit:      kitObj,
+		Lyrics:   lyricsObj,
+		Duration: int(duration),
 	}
 	return t, nil
 }
@@ -418,10 +530,10 @@ func (d *DB) UpdateTrack(track Track) error {
 	q := `
 update track
 set
-	title=$1, tempo=$2, click=$3, key_tone=$4, vox_id=$5, era_id=$6, genre_id=$7, kit_id=$8
+	title=$1, tempo=$2, click=$3, key_tone=$4, vox_id=$5, era_id=$6, genre_id=$7, kit_id=$8, duration=$10
 where id=$9`
 	_, err := d.db.Exec(q, track.Title, track.Tempo, track.Click, track.KeyTone, track.Vox.Id,
-		track.Era.Id, track.Genre.Id, track.Kit.Id, track.Id)
+		track.Era.Id, track.Genre.Id, track.Kit.Id, track.Id, track.Duration)
 	return err
 }
 
@@ -524,7 +636,8 @@ select
 	a_set.id, a_set.name, a_set.setnum 
 from setlist
 left join
-	a_set on a_set.setlist_id = setlist.id;`
+	a_set on a_set.setlist_id = setlist.id
+order by setlist.id, a_set.setnum, a_set.id;`
 
 	rows, err := d.db.Query(q)
 	if err != nil {
@@ -537,34 +650,42 @@ left join
 func (d *DB) GetSetlist(id int64) (Setlist, error) {
 	q := `
 SELECT 
-	setlist.name, setlist.timestamp, a_set.id 
+	setlist.name, setlist.timestamp, setlist.gap, setlist.set_target, a_set.id 
 FROM setlist
-JOIN
+LEFT JOIN
 	a_set on a_set.setlist_id = setlist.id
 WHERE
-	setlist.id = $1;`
+	setlist.id = $1
+ORDER BY a_set.setnum, a_set.id;`
 	rows, err := d.db.Query(q, id)
 	if err != nil {
 		return Setlist{}, err
 	}
+	defer rows.Close()
 	var (
-		name      string
-		timestamp int64
-		set_id    int64
+		name        string
+		timestamp   int64
+		gap         int64
+		set_target  int64
+		set_id_null sql.NullInt64
 	)
 	sets := []Set{}
 	for rows.Next() {
-		err = rows.Scan(&name, &timestamp, &set_id)
+		err = rows.Scan(&name, &timestamp, &gap, &set_target, &set_id_null)
 		if err != nil {
 			return Setlist{}, err
 		}
-		s, err := d.GetSet(set_id)
+		if !set_id_null.Valid {
+			// no sets yet
+			continue
+		}
+		s, err := d.GetSet(set_id_null.Int64)
 		if err != nil {
 			return Setlist{}, err
 		}
 		sets = append(sets, s)
 	}
-	sl := Setlist{Id: id, Name: name, Timestamp: timestamp, Sets: sets}
+	sl := Setlist{Id: id, Name: name, Timestamp: timestamp, Sets: sets, Gap: int(gap), SetTarget: int(set_target)}
 
 	return sl, nil
 }
@@ -616,8 +737,9 @@ func (d *DB) extractSetlists(rows *sql.Rows) ([]Setlist, error) {
 func (d *DB) GetSet(id int64) (Set, error) {
 	// DEB: fmt.Println("getSet ", id)
 	q := `
-SELECT a_set.name, a_set.setlist_id, a_set.setnum,
-	track.id, track.title, track.tempo, track.key_tone,
+SELECT a_set.name, a_set.setlist_id, a_set.setnum, a_set.target,
+	sets_tracks.id, sets_tracks.track_id, sets_tracks.title, sets_tracks.duration, sets_tracks.notes,
+	sets_tracks.segue, track.id, track.title, track.tempo, track.key_tone, track.duration,
 	vox.id, vox.name, 
 	era.id, era.name, 
 	genre.id, genre.name,
@@ -630,7 +752,7 @@ LEFT JOIN era on era.id = track.era_id
 LEFT JOIN genre on genre.id = track.genre_id
 LEFT JOIN kit on kit.id = track.kit_id
 WHERE a_set.id = $1
-ORDER BY sets_tracks.seq ASC;
+ORDER BY sets_tracks.seq ASC, sets_tracks.id ASC;
 `
 	rows, err := d.db.Query(q, id)
 	if err != nil {
@@ -641,10 +763,18 @@ ORDER BY sets_tracks.seq ASC;
 		name            string
 		setlist_id      int64
 		setnum          int64
+		target          int64
+		entry_null      sql.NullInt64
+		entry_track     sql.NullInt64
+		item_title      sql.NullString
+		item_duration   sql.NullInt64
+		item_notes      sql.NullString
+		segue_null      sql.NullBool
 		track_id_null   sql.NullInt64
 		title_null      sql.NullString
 		tempo_null      sql.NullInt64
 		key_tone_null   sql.NullString
+		duration_null   sql.NullInt64
 		vox_id_null     sql.NullInt64
 		vox_name_null   sql.NullString
 		era_id_null     sql.NullInt64
@@ -656,7 +786,7 @@ ORDER BY sets_tracks.seq ASC;
 	)
 	s := Set{}
 	for rows.Next() {
-		err = rows.Scan(&name, &setlist_id, &setnum, &track_id_null, &title_null, &tempo_null, &key_tone_null,
+		err = rows.Scan(&name, &setlist_id, &setnum, &target, &entry_null, &entry_track, &item_title, &item_duration, &item_notes, &segue_null, &track_id_null, &title_null, &tempo_null, &key_tone_null, &duration_null,
 			&vox_id_null, &vox_name_null, &era_id_null, &era_name_null, &genre_id_null, &genre_name_null,
 			&kit_id_null, &kit_name_null)
 		if err != nil {
@@ -664,10 +794,19 @@ ORDER BY sets_tracks.seq ASC;
 		}
 		if s.Id == 0 {
 			s.Id = id
+			s.SetlistId = setlist_id
 			s.Name = name
 			s.SetNum = int(setnum)
+			s.Target = int(target)
 		}
 		track_id := int64(0)
+		if entry_null.Valid && entry_track.Int64 == 0 {
+			// not a song, just what's on the row
+			t := Track{Entry: entry_null.Int64, Title: item_title.String,
+				Duration: int(item_duration.Int64), Notes: item_notes.String, Segue: segue_null.Bool}
+			s.Tracks = append(s.Tracks, t)
+			continue
+		}
 		// if track id is valid, check everything else. Otherwise, no need
 		if track_id_null.Valid {
 			track_id = track_id_null.Int64
@@ -724,7 +863,8 @@ ORDER BY sets_tracks.seq ASC;
 			k := Kit{Id: kit_id, Name: kit_name}
 			t := Track{Id: track_id, Title: title,
 				Vox: v, Era: e, Genre: g, Tempo: int(tempo),
-				KeyTone: key_tone, Kit: k}
+				KeyTone: key_tone, Kit: k, Duration: int(duration_null.Int64), Entry: entry_null.Int64,
+				Segue: segue_null.Bool}
 			s.Tracks = append(s.Tracks, t)
 		} else {
 			// DEB: fmt.Println("no track id")
@@ -734,10 +874,16 @@ ORDER BY sets_tracks.seq ASC;
 	return s, nil
 }
 
+// AddSet puts a new set in at s.SetNum, moving the sets from there down one
 func (d *DB) AddSet(s Set) (int64, error) {
 	// DEB: fmt.Println("Adding set:", s.Name)
-	q := "insert into a_set (setlist_id, name, setnum) values ($1, $2, $3);"
-	res, err := d.db.Exec(q, s.SetlistId, s.Name, s.SetNum)
+	tx, err := d.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+	q := "insert into a_set (setlist_id, name, setnum, target) values ($1, $2, $3, $4);"
+	res, err := tx.Exec(q, s.SetlistId, s.Name, s.SetNum, s.Target)
 	if err != nil {
 		return -1, err
 	}
@@ -745,38 +891,144 @@ func (d *DB) AddSet(s Set) (int64, error) {
 	if err != nil {
 		return -1, err
 	}
-	return id, nil
+	err = placeSet(tx, s.SetlistId, id, s.SetNum)
+	if err != nil {
+		return -1, err
+	}
+	return id, tx.Commit()
 }
 
+// UpdateSet renames the set, sets its target and moves it to s.SetNum
 func (d *DB) UpdateSet(s Set) error {
 	// DEB: fmt.Println("Updating set:", s.Name)
-	q := "update a_set set name=$1 where id=$2"
-	_, err := d.db.Exec(q, s.Name, s.Id)
-	return err
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var setlist_id int64
+	err = tx.QueryRow("select setlist_id from a_set where id=$1;", s.Id).Scan(&setlist_id)
+	if err != nil {
+		return err
+	}
+	q := "update a_set set name=$1, target=$3 where id=$2"
+	_, err = tx.Exec(q, s.Name, s.Id, s.Target)
+	if err != nil {
+		return err
+	}
+	err = placeSet(tx, setlist_id, s.Id, s.SetNum)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (d *DB) AddTrackToSet(sid int64, tid int64) error {
-	// DEB: fmt.Printf("Adding track %d to set %d\n", tid, sid)
-	q := "select max(seq) from sets_tracks where set_id=$1;"
-	var seq_null sql.NullInt64
-	err := d.db.QueryRow(q, sid).Scan(&seq_null)
+// MoveSet moves the set to setnum, and says which setlist it's in
+func (d *DB) MoveSet(id int64, setnum int) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+	var setlist_id int64
+	err = tx.QueryRow("select setlist_id from a_set where id=$1;", id).Scan(&setlist_id)
+	if err != nil {
+		return -1, err
+	}
+	err = placeSet(tx, setlist_id, id, setnum)
+	if err != nil {
+		return -1, err
+	}
+	return setlist_id, tx.Commit()
+}
+
+// DelSet removes a set and its songs, the sets after it move up
+func (d *DB) DelSet(id int64) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+	var setlist_id int64
+	err = tx.QueryRow("select setlist_id from a_set where id=$1;", id).Scan(&setlist_id)
+	if err != nil {
+		return -1, err
+	}
+	for _, q := range []string{
+		"delete from sets_tracks where set_id=$1;",
+		"delete from a_set where id=$1;",
+	} {
+		_, err = tx.Exec(q, id)
+		if err != nil {
+			return -1, err
+		}
+	}
+	err = placeSet(tx, setlist_id, 0, 0)
+	if err != nil {
+		return -1, err
+	}
+	return setlist_id, tx.Commit()
+}
+
+// placeSet moves set id to position setnum in the setlist (it goes last if
+// that's past the end) and numbers the setlist's sets 0, 1, 2... again.
+// An id of 0 just renumbers.
+func placeSet(tx *sql.Tx, setlist_id int64, id int64, setnum int) error {
+	q := "select id from a_set where setlist_id=$1 and id!=$2 order by setnum, id;"
+	rows, err := tx.Query(q, setlist_id, id)
 	if err != nil {
 		return err
 	}
-	seq := 0
-	if seq_null.Valid {
-		// DEB: fmt.Println(" seq:", seq_null.Int64)
-		seq = int(seq_null.Int64)
+	ids := []int64{}
+	for rows.Next() {
+		var sid int64
+		err = rows.Scan(&sid)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, sid)
+	}
+	rows.Close()
+	if id != 0 {
+		ids = slices.Insert(ids, min(max(setnum, 0), len(ids)), id)
+	}
+	for num, sid := range ids {
+		_, err = tx.Exec("update a_set set setnum=$1 where id=$2;", num, sid)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	q = "insert into sets_tracks (set_id, track_id, seq) values ($1, $2, $3)"
-	_, err = d.db.Exec(q, sid, tid, seq)
+func (d *DB) AddTrackToSet(sid int64, tid int64) error {
+	// DEB: fmt.Printf("Adding track %d to set %d\n", tid, sid)
+	q := "insert into sets_tracks (set_id, track_id, seq) select $1, $2, coalesce(max(seq)+1, 0) from sets_tracks where set_id=$1;"
+	_, err := d.db.Exec(q, sid, tid)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// AddItemToSet puts something that isn't a song (an intro, a raffle, a
+// break) at the end of the set. Only its Title, Duration and Notes are kept.
+func (d *DB) AddItemToSet(sid int64, item Track) error {
+	q := `
+insert into sets_tracks (set_id, track_id, seq, title, duration, notes)
+select $1, 0, coalesce(max(seq)+1, 0), $2, $3, $4 from sets_tracks where set_id=$1;`
+	_, err := d.db.Exec(q, sid, item.Title, item.Duration, item.Notes)
+	return err
+}
+
+// RemEntryFromSet takes one song or item out of the set
+func (d *DB) RemEntryFromSet(sid int64, entry int64) error {
+	q := "delete from sets_tracks where set_id=$1 and id=$2;"
+	_, err := d.db.Exec(q, sid, entry)
+	return err
+}
+
 func (d *DB) RemTrackFromSet(sid int64, tid int64) error {
 	// DEB: fmt.Printf("Removing track %d from set %d\n", sid, tid)
 	q := "delete from sets_tracks where set_id = $1 and track_id =$2"
@@ -789,8 +1041,8 @@ func (d *DB) RemTrackFromSet(sid int64, tid int64) error {
 
 func (d *DB) UpdateSetlist(s Setlist) error {
 	// DEB: fmt.Println("updating setlist:", s.Name)
-	q := "update setlist set name=$1 where id=$2;"
-	_, err := d.db.Exec(q, s.Name, s.Id)
+	q := "update setlist set name=$1, gap=$3, set_target=$4 where id=$2;"
+	_, err := d.db.Exec(q, s.Name, s.Id, s.Gap, s.SetTarget)
 	return err
 }
 
@@ -809,6 +1061,132 @@ func (d *DB) AddSetlist(s Setlist) (int64, error) {
 	return id, nil
 }
 
+// AddSetlistWithSets saves a whole setlist, its sets and their songs in order
+func (d *DB) AddSetlistWithSets(sl Setlist) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+	q := "insert into setlist (name, timestamp, gap, set_target) values ($1, $2, $3, $4);"
+	res, err := tx.Exec(q, sl.Name, time.Now().Unix(), sl.Gap, sl.SetTarget)
+	if err != nil {
+		return -1, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	for setnum, s := range sl.Sets {
+		q = "insert into a_set (setlist_id, name, setnum, target) values ($1, $2, $3, $4);"
+		res, err = tx.Exec(q, id, s.Name, setnum, s.Target)
+		if err != nil {
+			return -1, err
+		}
+		sid, err := res.LastInsertId()
+		if err != nil {
+			return -1, err
+		}
+		for seq, t := range s.Tracks {
+			item := Track{}
+			if t.IsItem() {
+				item = t
+			}
+			q = "insert into sets_tracks (set_id, track_id, seq, title, duration, notes, segue) values ($1, $2, $3, $4, $5, $6, $7);"
+			_, err = tx.Exec(q, sid, t.Id, seq, item.Title, item.Duration, item.Notes, t.Segue)
+			if err != nil {
+				return -1, err
+			}
+		}
+	}
+	return id, tx.Commit()
+}
+
+// CloneSetlist copies a setlist with all its sets and their songs under a new name
+func (d *DB) CloneSetlist(id int64, name string) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+	q := `
+insert into setlist (name, timestamp, gap, set_target)
+select $1, $2, gap, set_target from setlist where id=$3;`
+	res, err := tx.Exec(q, name, time.Now().Unix(), id)
+	if err != nil {
+		return -1, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return -1, fmt.Errorf("no setlist %d", id)
+	}
+	newId, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	rows, err := tx.Query("select id, setnum from a_set where setlist_id=$1 order by setnum;", id)
+	if err != nil {
+		return -1, err
+	}
+	sets := [][2]int64{}
+	for rows.Next() {
+		var sid, setnum int64
+		err = rows.Scan(&sid, &setnum)
+		if err != nil {
+			rows.Close()
+			return -1, err
+		}
+		sets = append(sets, [2]int64{sid, setnum})
+	}
+	rows.Close()
+	for _, s := range sets {
+		_, err = copySet(tx, s[0], newId, s[1])
+		if err != nil {
+			return -1, err
+		}
+	}
+	return newId, tx.Commit()
+}
+
+// CopySet copies a set and its songs onto the end of a setlist
+func (d *DB) CopySet(sid int64, setlistId int64) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+	var setnum int64
+	q := "select coalesce(max(setnum)+1, 0) from a_set where setlist_id=$1;"
+	err = tx.QueryRow(q, setlistId).Scan(&setnum)
+	if err != nil {
+		return -1, err
+	}
+	newId, err := copySet(tx, sid, setlistId, setnum)
+	if err != nil {
+		return -1, err
+	}
+	return newId, tx.Commit()
+}
+
+func copySet(tx *sql.Tx, sid int64, setlistId int64, setnum int64) (int64, error) {
+	q := "insert into a_set (setlist_id, name, setnum, target) select $1, name, $2, target from a_set where id=$3;"
+	res, err := tx.Exec(q, setlistId, setnum, sid)
+	if err != nil {
+		return -1, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return -1, fmt.Errorf("no set %d", sid)
+	}
+	newId, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	q = `
+insert into sets_tracks (set_id, track_id, seq, title, duration, notes, segue)
+select $1, track_id, seq, title, duration, notes, segue from sets_tracks where set_id=$2 order by seq, id;`
+	_, err = tx.Exec(q, newId, sid)
+	return newId, err
+}
+
 func (d *DB) insertName(table string, name string) (int64, error) {
 	q := fmt.Sprintf("insert into %s (name) values ('%s');", table, name)
 	res, err := d.db.Exec(q)
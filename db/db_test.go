@@ -0,0 +1,76 @@
+package db
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMigrateSetEntries(t *testing.T) {
+	d, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sets_tracks the way it was, no id and every seq 0, in order by rowid
+	_, err = d.db.Exec(`
+	drop table sets_tracks;
+	create table sets_tracks (set_id INTEGER NOT NULL, track_id INTEGER NOT NULL, seq INTEGER NOT NULL,
+		title TEXT NOT NULL DEFAULT '', duration INTEGER NOT NULL DEFAULT 0, notes TEXT NOT NULL DEFAULT '',
+		segue INTEGER NOT NULL DEFAULT 0);
+	insert into a_set (id, setlist_id, name, setnum) values (1, 1, 'one', 0), (2, 1, 'two', 1);
+	insert into sets_tracks (rowid, set_id, track_id, seq, title) values
+		(5, 1, 0, 0, 'c'), (7, 2, 0, 0, 'x'), (9, 1, 0, 0, 'a'), (11, 1, 0, 0, 'b'), (12, 2, 0, 3, 'z'), (13, 2, 0, 1, 'y');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 { // and again, it's only done once
+		if err = d.migrateSetEntries(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries := func(sid int64) ([]int64, []string) {
+		s, err := d.GetSet(sid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, titles := []int64{}, []string{}
+		for _, tr := range s.Tracks {
+			ids, titles = append(ids, tr.Entry), append(titles, tr.Title)
+		}
+		return ids, titles
+	}
+	check := func(sid int64, wantIds []int64, wantTitles []string) {
+		t.Helper()
+		ids, titles := entries(sid)
+		if !slices.Equal(ids, wantIds) || !slices.Equal(titles, wantTitles) {
+			t.Errorf("set %d is %v %q, want %v %q", sid, ids, titles, wantIds, wantTitles)
+		}
+	}
+	check(1, []int64{5, 9, 11}, []string{"c", "a", "b"})
+	check(2, []int64{7, 13, 12}, []string{"x", "y", "z"})
+
+	var seqs string
+	err = d.db.QueryRow("select group_concat(seq, ' ') from (select seq from sets_tracks order by set_id, seq);").Scan(&seqs)
+	if err != nil || seqs != "0 1 2 0 1 2" {
+		t.Errorf("seqs %q (%v), want 0 1 2 0 1 2", seqs, err)
+	}
+
+	// entries are found by their id afterwards
+	if err = d.AddItemToSet(1, Track{Title: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.MoveEntry(1, 11, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.RemEntryFromSet(1, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.ToggleSegue(1, 9); err != nil {
+		t.Fatal(err)
+	}
+	check(1, []int64{11, 9, 14}, []string{"b", "a", "d"})
+	s, _ := d.GetSet(1)
+	if !s.Tracks[1].Segue {
+		t.Errorf("entry 9 doesn't segue after ToggleSegue")
+	}
+}
@@ -9,22 +9,33 @@ import (
 )
 
 type Track struct {
-	Id      int64
-	Title   string
-	Tempo   int
-	Click   bool
-	KeyTone string
-	Vox     Vox
-	Era     Era
-	Genre   Genre
-	Kit     Kit
-	Lyrics  Lyrics
+	Id       int64
+	Title    string
+	Tempo    int
+	Click    bool
+	KeyTone  string
+	Vox      Vox
+	Era      Era
+	Genre    Genre
+	Kit      Kit
+	Lyrics   Lyrics
+	Duration int // seconds, 0 if not known
+	// in a set
+	Entry int64  // the id of the set's row for it in sets_tracks
+	Notes string // items only
+	Segue bool   // runs straight into the next entry without stopping
 }
 
 func (t Track) ProperTitle() string {
 	return toTitle(t.Title)
 }
 
+// IsItem is true for the things in a set that aren't songs, like a raffle
+// or a break. They have a title and maybe a length and notes, nothing else.
+func (t Track) IsItem() bool {
+	return t.Id == 0
+}
+
 type Child interface {
 	ProperName() string
 }
@@ -80,11 +91,31 @@ func (l Lyrics) PrettyText(maxRows int) template.HTML {
 	return m.PrettyText(maxRows)
 }
 
+// Diagnostics are the markup problems in the raw text, if any
+func (l Lyrics) Diagnostics() []Diagnostic {
+	return ParseMark(l.RawText).Diags
+}
+
+// GigText is PrettyText with repeated sections written out for reading on stage
+func (l Lyrics) GigText(maxRows int) template.HTML {
+	m := NewMarkText(l.RawText)
+	m.Expand = true
+	return m.PrettyText(maxRows)
+}
+
+// GigSections are the section headings of GigText
+func (l Lyrics) GigSections() []Section {
+	m := NewMarkText(l.RawText)
+	m.Expand = true
+	return m.Sections()
+}
+
 type Set struct {
 	Id        int64
 	SetlistId int64
 	SetNum    int
 	Name      string
+	Target    int // seconds this set should run, 0 for the setlist's SetTarget
 	Tracks    []Track
 }
 
@@ -92,8 +123,15 @@ func (s Set) ProperName() string {
 	return toTitle(s.Name)
 }
 
+// TrackCount is the songs in the set, not counting other items
 func (s Set) TrackCount() int {
-	return len(s.Tracks)
+	n := 0
+	for _, t := range s.Tracks {
+		if !t.IsItem() {
+			n++
+		}
+	}
+	return n
 }
 
 type Setlist struct {
@@ -101,6 +139,8 @@ type Setlist struct {
 	Name      string
 	Sets      []Set
 	Timestamp int64
+	Gap       int // seconds between songs
+	SetTarget int // seconds each set should run unless it has its own Target, 0 for none
 }
 
 func (s Setlist) ProperName() string {
@@ -116,37 +156,58 @@ func (s Setlist) CreatedAt() string {
 	return t.Local().Format("2 Jan 2006 - 15:04:05")
 }
 
-type Gig struct {
-	Id       int64
-	Name     string // comes from setlist name
-	CurSet   int    // index to current set
-	CurTrack int    // index to current track
-	Sets     []Set
+type Venue struct {
+	Id      int64
+	Name    string
+	Address string
+	Notes   string
 }
 
-func NewGig(setlist Setlist) *Gig {
-	g := &Gig{Name: setlist.Name, CurSet: 0, CurTrack: 0}
-	g.Sets = append(g.Sets, setlist.Sets...)
-	return g
+func (v Venue) ProperName() string {
+	return toTitle(v.Name)
 }
 
-func (g Gig) ProperName() string {
-	return toTitle(g.Name)
+// Event is a date we're playing. Date is "2006-01-02", the times "15:04" (either may be blank).
+type Event struct {
+	Id        int64
+	Name      string
+	Date      string
+	CallTime  string
+	StartTime string
+	Venue     Venue
+	Contact   string
+	Notes     string
+	SetlistId int64 // 0 if no setlist is picked yet
+	Setlist   string
+}
+
+const (
+	DateFormat = "2006-01-02"
+	TimeFormat = "15:04"
+)
+
+func (e Event) ProperName() string {
+	return toTitle(e.Name)
 }
 
-// return next Track or false if no more
-/*
-func (g *Gig) NextTrack() (Track, bool) {
-	if len(g.Sets[g.CurSet].Tracks) < 1 {
-		// go to next set, if exists
-		g.CurSet += 1
-		if g.CurSet >= len(g.Sets) {
-			// no more tracks
-			return Track{}, false
-		}
+func (e Event) ProperSetlist() string {
+	return toTitle(e.Setlist)
+}
+
+// Day is the date written out, "Fri 24 Oct 2026"
+func (e Event) Day() string {
+	t, err := time.ParseInLocation(DateFormat, e.Date, time.Local)
+	if err != nil {
+		return e.Date
 	}
-	t := g.Sets[g.CurSet].Tracks[0]
-	g.Sets[g.CurSet].Tracks = g.Sets[g.CurSet].Tracks[1:]
-	return t, true
+	return t.Format("Mon 2 Jan 2006")
+}
+
+// At is the local time of a clock time on the event's date
+func (e Event) At(clock string) (time.Time, error) {
+	return time.ParseInLocation(DateFormat+" "+TimeFormat, e.Date+" "+clock, time.Local)
+}
+
+func (e Event) IsToday() bool {
+	return e.Date == time.Now().Format(DateFormat)
 }
-*/
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+var eventSchema string = `
+	create table if not exists venue (
+		id INTEGER primary key,
+		name TEXT NOT NULL UNIQUE,
+		address TEXT NOT NULL DEFAULT '',
+		notes TEXT NOT NULL DEFAULT ''
+	);
+	create table if not exists event (
+		id INTEGER primary key,
+		name TEXT NOT NULL,
+		date TEXT NOT NULL,
+		call_time TEXT NOT NULL DEFAULT '',
+		start_time TEXT NOT NULL DEFAULT '',
+		venue_id INTEGER NOT NULL DEFAULT 0,
+		contact TEXT NOT NULL DEFAULT '',
+		notes TEXT NOT NULL DEFAULT '',
+		setlist_id INTEGER NOT NULL DEFAULT 0
+	);
+`
+
+func (d *DB) GetAllVenues() ([]Venue, error) {
+	q := "select id, name, address, notes from venue order by name;"
+	rows, err := d.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	venues := []Venue{}
+	for rows.Next() {
+		v := Venue{}
+		err = rows.Scan(&v.Id, &v.Name, &v.Address, &v.Notes)
+		if err != nil {
+			return nil, err
+		}
+		venues = append(venues, v)
+	}
+	return venues, rows.Err()
+}
+
+func (d *DB) GetVenue(id int64) (Venue, error) {
+	q := "select id, name, address, notes from venue where id = $1;"
+	v := Venue{}
+	err := d.db.QueryRow(q, id).Scan(&v.Id, &v.Name, &v.Address, &v.Notes)
+	return v, err
+}
+
+func (d *DB) AddVenue(v Venue) (int64, error) {
+	q := "insert into venue (name, address, notes) values ($1, $2, $3);"
+	res, err := d.db.Exec(q, v.Name, v.Address, v.Notes)
+	if err != nil {
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+func (d *DB) UpdateVenue(v Venue) error {
+	q := "update venue set name=$2, address=$3, notes=$4 where id=$1;"
+	_, err := d.db.Exec(q, v.Id, v.Name, v.Address, v.Notes)
+	return err
+}
+
+var eventSelect string = `
+select event.id, event.name, event.date, event.call_time, event.start_time,
+	event.venue_id, coalesce(venue.name, ''), coalesce(venue.address, ''), coalesce(venue.notes, ''),
+	event.contact, event.notes, event.setlist_id, coalesce(setlist.name, '')
+from event
+left join venue on venue.id = event.venue_id
+left join setlist on setlist.id = event.setlist_id
+`
+
+// GetAllEvents is every event, soonest first
+func (d *DB) GetAllEvents() ([]Event, error) {
+	q := eventSelect + "order by event.date, event.start_time;"
+	rows, err := d.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractEvents(rows)
+}
+
+// GetUpcomingEvents is today's events and later ones
+func (d *DB) GetUpcomingEvents() ([]Event, error) {
+	q := eventSelect + "where event.date >= $1 order by event.date, event.start_time;"
+	rows, err := d.db.Query(q, time.Now().Format(DateFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractEvents(rows)
+}
+
+func (d *DB) GetVenueEvents(venue_id int64) ([]Event, error) {
+	q := eventSelect + "where event.venue_id = $1 order by event.date desc;"
+	rows, err := d.db.Query(q, venue_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractEvents(rows)
+}
+
+func (d *DB) GetSetlistEvents(setlist_id int64) ([]Event, error) {
+	q := eventSelect + "where event.setlist_id = $1 order by event.date desc;"
+	rows, err := d.db.Query(q, setlist_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractEvents(rows)
+}
+
+func (d *DB) GetEvent(id int64) (Event, error) {
+	q := eventSelect + "where event.id = $1;"
+	rows, err := d.db.Query(q, id)
+	if err != nil {
+		return Event{}, err
+	}
+	defer rows.Close()
+	events, err := extractEvents(rows)
+	if err != nil {
+		return Event{}, err
+	}
+	if len(events) == 0 {
+		return Event{}, sql.ErrNoRows
+	}
+	return events[0], nil
+}
+
+func extractEvents(rows *sql.Rows) ([]Event, error) {
+	events := []Event{}
+	for rows.Next() {
+		e := Event{}
+		err := rows.Scan(&e.Id, &e.Name, &e.Date, &e.CallTime, &e.StartTime,
+			&e.Venue.Id, &e.Venue.Name, &e.Venue.Address, &e.Venue.Notes,
+			&e.Contact, &e.Notes, &e.SetlistId, &e.Setlist)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (d *DB) AddEvent(e Event) (int64, error) {
+	q := `insert into event (name, date, call_time, start_time, venue_id, contact, notes, setlist_id)
+	values ($1, $2, $3, $4, $5, $6, $7, $8);`
+	res, err := d.db.Exec(q, e.Name, e.Date, e.CallTime, e.StartTime, e.Venue.Id, e.Contact, e.Notes, e.SetlistId)
+	if err != nil {
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+func (d *DB) UpdateEvent(e Event) error {
+	q := `update event set name=$2, date=$3, call_time=$4, start_time=$5, venue_id=$6, contact=$7, notes=$8, setlist_id=$9
+	where id=$1;`
+	_, err := d.db.Exec(q, e.Id, e.Name, e.Date, e.CallTime, e.StartTime, e.Venue.Id, e.Contact, e.Notes, e.SetlistId)
+	return err
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+// Flow rules are the band's rules of thumb for running order, like no more
+// than three in a row for one singer. They're kept in the db and checked
+// against sets, anything broken shows up as a warning, never an error.
+
+var flowSchema string = `
+	create table if not exists flow_rule (
+		id INTEGER primary key,
+		kind TEXT NOT NULL,
+		value INTEGER NOT NULL,
+		genre_id INTEGER NOT NULL DEFAULT 0
+	);
+`
+
+// kinds of rule, Value is a count of songs or a tempo
+const (
+	RuleVoxRun     string = "vox_run"     // at most Value songs in a row for one singer
+	RuleGenreRun   string = "genre_run"   // at most Value songs of Genre in a row
+	RuleOpenTempo  string = "open_tempo"  // first song at Value BPM or more
+	RuleCloseTempo string = "close_tempo" // last song at Value BPM or more
+	RuleSegueKit   string = "segue_kit"   // same kit across a segue, no Value
+)
+
+type RuleKind struct {
+	Kind  string
+	Name  string
+	Value string // what Value means, "" if the rule doesn't take one
+	Genre bool   // if the rule needs a genre
+}
+
+var RuleKinds = []RuleKind{
+	{RuleVoxRun, "Most songs in a row for one singer", "songs", false},
+	{RuleGenreRun, "Most songs in a row of a genre", "songs", true},
+	{RuleOpenTempo, "Slowest tempo to open a set", "BPM", false},
+	{RuleCloseTempo, "Slowest tempo to close a set", "BPM", false},
+	{RuleSegueKit, "No kit change between segued songs", "", false},
+}
+
+type Rule struct {
+	Id    int64
+	Kind  string
+	Value int
+	Genre Genre // for genre_run
+}
+
+// Describe is the rule in words
+func (r Rule) Describe() string {
+	switch r.Kind {
+	case RuleVoxRun:
+		return fmt.Sprintf("no more than %d songs in a row for one singer", r.Value)
+	case RuleGenreRun:
+		return fmt.Sprintf("no more than %d %s songs in a row", r.Value, r.Genre.ProperName())
+	case RuleOpenTempo:
+		return fmt.Sprintf("open each set at %d BPM or more", r.Value)
+	case RuleCloseTempo:
+		return fmt.Sprintf("close each set at %d BPM or more", r.Value)
+	case RuleSegueKit:
+		return "no kit change between segued songs"
+	}
+	return "unknown rule " + r.Kind
+}
+
+// FlowWarning is a rule a set breaks, at songs Pos to Last (from 1). Songs
+// with no tempo set don't break tempo rules, and items that aren't songs end
+// a run.
+type FlowWarning struct {
+	Rule    Rule
+	Pos     int
+	Last    int
+	Message string
+}
+
+// Check is where the set breaks the rule
+func (r Rule) Check(s Set) []FlowWarning {
+	warns := []FlowWarning{}
+	warn := func(pos, last int, format string, args ...any) {
+		warns = append(warns, FlowWarning{Rule: r, Pos: pos, Last: last, Message: fmt.Sprintf(format, args...)})
+	}
+	first, last := -1, -1
+	for i, t := range s.Tracks {
+		if !t.IsItem() {
+			last = i
+			if first == -1 {
+				first = i
+			}
+		}
+	}
+	song := func(t Track) bool { return !t.IsItem() }
+	switch r.Kind {
+	case RuleVoxRun:
+		runs(s.Tracks, song, func(a, b Track) bool { return a.Vox.Id == b.Vox.Id },
+			func(start, end int) {
+				if end-start > r.Value {
+					warn(start+1, end, "%d songs in a row for %s (#%d-#%d)", end-start, s.Tracks[start].Vox.ProperName(), start+1, end)
+				}
+			})
+	case RuleGenreRun:
+		runs(s.Tracks, func(t Track) bool { return song(t) && t.Genre.Id == r.Genre.Id }, func(a, b Track) bool { return true },
+			func(start, end int) {
+				if end-start > r.Value {
+					warn(start+1, end, "%d %s songs in a row (#%d-#%d)", end-start, r.Genre.ProperName(), start+1, end)
+				}
+			})
+	case RuleOpenTempo:
+		if first == -1 {
+			break
+		}
+		if t := s.Tracks[first]; t.Tempo != 0 && t.Tempo < r.Value {
+			warn(first+1, first+1, "opens with %s at %d BPM, under %d", t.ProperTitle(), t.Tempo, r.Value)
+		}
+	case RuleCloseTempo:
+		if last == -1 {
+			break
+		}
+		if t := s.Tracks[last]; t.Tempo != 0 && t.Tempo < r.Value {
+			warn(last+1, last+1, "closes with %s at %d BPM, under %d", t.ProperTitle(), t.Tempo, r.Value)
+		}
+	case RuleSegueKit:
+		for i, a := range s.Tracks {
+			b, ok := s.SegueInto(i)
+			if ok && song(a) && song(b) && a.Kit.Id != b.Kit.Id {
+				warn(i+1, i+2, "kit change from %s to %s in the segue into %s", a.Kit.ProperName(), b.Kit.ProperName(), b.ProperTitle())
+			}
+		}
+	}
+	return warns
+}
+
+// runs calls found with each stretch [start, end) of songs that all match
+// and go together
+func runs(tracks []Track, match func(Track) bool, together func(a, b Track) bool, found func(start, end int)) {
+	start := -1
+	for i, t := range tracks {
+		if start != -1 && (!match(t) || !together(tracks[start], t)) {
+			found(start, i)
+			start = -1
+		}
+		if start == -1 && match(t) {
+			start = i
+		}
+	}
+	if start != -1 {
+		found(start, len(tracks))
+	}
+}
+
+// CheckSet is every rule the set breaks, in running order
+func CheckSet(rules []Rule, s Set) []FlowWarning {
+	warns := []FlowWarning{}
+	for _, r := range rules {
+		warns = append(warns, r.Check(s)...)
+	}
+	slices.SortStableFunc(warns, func(a, b FlowWarning) int { return cmp.Compare(a.Pos, b.Pos) })
+	return warns
+}
+
+func (d *DB) GetRules() ([]Rule, error) {
+	q := `
+select flow_rule.id, flow_rule.kind, flow_rule.value, flow_rule.genre_id, genre.name
+from flow_rule
+left join genre on genre.id = flow_rule.genre_id
+order by flow_rule.id;`
+	rows, err := d.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []Rule{}
+	for rows.Next() {
+		var (
+			r     Rule
+			genre sql.NullString
+		)
+		err = rows.Scan(&r.Id, &r.Kind, &r.Value, &r.Genre.Id, &genre)
+		if err != nil {
+			return nil, err
+		}
+		r.Genre.Name = genre.String
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (d *DB) AddRule(r Rule) (int64, error) {
+	q := "insert into flow_rule (kind, value, genre_id) values ($1, $2, $3);"
+	res, err := d.db.Exec(q, r.Kind, r.Value, r.Genre.Id)
+	if err != nil {
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+func (d *DB) DelRule(id int64) error {
+	_, err := d.db.Exec("delete from flow_rule where id=$1;", id)
+	return err
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestRuleCheck(t *testing.T) {
+	ann, bob := Vox{Id: 1, Name: "ann"}, Vox{Id: 2, Name: "bob"}
+	funk := Genre{Id: 3, Name: "funk"}
+	sung := func(id int64, v Vox) Track { return Track{Id: id, Title: "song", Vox: v} }
+	at := func(id int64, tempo int) Track { return Track{Id: id, Title: "song", Tempo: tempo} }
+	raffle := Track{Title: "raffle"}
+	tests := []struct {
+		name   string
+		rule   Rule
+		tracks []Track
+		want   []string // as "#pos-#last message"
+	}{
+		{"vox run ok", Rule{Kind: RuleVoxRun, Value: 2}, []Track{sung(1, ann), sung(2, ann), sung(3, bob), sung(4, ann)}, []string{}},
+		{"vox run", Rule{Kind: RuleVoxRun, Value: 2}, []Track{sung(1, bob), sung(2, ann), sung(3, ann), sung(4, ann), sung(5, bob)},
+			[]string{"#2-#4 3 songs in a row for Ann (#2-#4)"}},
+		{"vox runs to the end", Rule{Kind: RuleVoxRun, Value: 1}, []Track{sung(1, ann), sung(2, bob), sung(3, bob)},
+			[]string{"#2-#3 2 songs in a row for Bob (#2-#3)"}},
+		{"item ends a vox run", Rule{Kind: RuleVoxRun, Value: 2}, []Track{sung(1, ann), sung(2, ann), raffle, sung(3, ann), sung(4, ann)}, []string{}},
+		{"genre run", Rule{Kind: RuleGenreRun, Value: 1, Genre: funk},
+			[]Track{{Id: 1, Genre: funk}, {Id: 2}, {Id: 3, Genre: funk}, {Id: 4, Genre: funk}},
+			[]string{"#3-#4 2 Funk songs in a row (#3-#4)"}},
+		{"open tempo", Rule{Kind: RuleOpenTempo, Value: 120}, []Track{at(1, 90), at(2, 140)}, []string{"#1-#1 opens with Song at 90 BPM, under 120"}},
+		{"open tempo past an item", Rule{Kind: RuleOpenTempo, Value: 120}, []Track{raffle, at(1, 90)}, []string{"#2-#2 opens with Song at 90 BPM, under 120"}},
+		{"open tempo unknown", Rule{Kind: RuleOpenTempo, Value: 120}, []Track{at(1, 0), at(2, 90)}, []string{}},
+		{"open tempo ok", Rule{Kind: RuleOpenTempo, Value: 120}, []Track{at(1, 120)}, []string{}},
+		{"close tempo", Rule{Kind: RuleCloseTempo, Value: 120}, []Track{at(1, 140), at(2, 100), raffle}, []string{"#2-#2 closes with Song at 100 BPM, under 120"}},
+		{"tempo in an empty set", Rule{Kind: RuleCloseTempo, Value: 120}, []Track{raffle}, []string{}},
+		{"segue kit", Rule{Kind: RuleSegueKit},
+			[]Track{{Id: 1, Segue: true, Kit: Kit{Id: 1, Name: "rock"}}, {Id: 2, Title: "next", Kit: Kit{Id: 2, Name: "jazz"}}, {Id: 3, Kit: Kit{Id: 1}}},
+			[]string{"#1-#2 kit change from Rock to Jazz in the segue into Next"}},
+		{"segue kit same", Rule{Kind: RuleSegueKit}, []Track{{Id: 1, Segue: true, Kit: Kit{Id: 1}}, {Id: 2, Kit: Kit{Id: 1}}}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, w := range tt.rule.Check(Set{Tracks: tt.tracks}) {
+				got = append(got, fmtWarning(w))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func fmtWarning(w FlowWarning) string {
+	return fmt.Sprintf("#%d-#%d %s", w.Pos, w.Last, w.Message)
+}
+
+func TestCheckSet(t *testing.T) {
+	rules := []Rule{{Kind: RuleCloseTempo, Value: 120}, {Kind: RuleOpenTempo, Value: 120}}
+	s := Set{Tracks: []Track{{Id: 1, Tempo: 90}, {Id: 2, Tempo: 90}}}
+	pos := []int{}
+	for _, w := range CheckSet(rules, s) {
+		pos = append(pos, w.Pos)
+	}
+	if want := []int{1, 2}; !slices.Equal(pos, want) {
+		t.Errorf("warnings at %v, want %v in running order", pos, want)
+	}
+}
@@ -0,0 +1,318 @@
+package db
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+)
+
+// Formats are setlist templates for the gigs that always run the same way,
+// like a wedding: named sets with a target length, and slots for the songs
+// that have to be there (the first dance, a singalong to close). Making a
+// setlist from one fills each slot with a song from the catalog that fits,
+// then tops the set up to its length like the generator does. A set with no
+// slots and no length is a break, it stays empty and the gig skips it.
+
+var formatSchema string = `
+	create table if not exists format (
+		id INTEGER primary key,
+		name TEXT NOT NULL,
+		gap INTEGER NOT NULL DEFAULT 20
+	);
+	create table if not exists format_set (
+		id INTEGER primary key,
+		format_id INTEGER NOT NULL,
+		setnum INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		target INTEGER NOT NULL DEFAULT 0
+	);
+	create table if not exists format_slot (
+		id INTEGER primary key,
+		set_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		at_end INTEGER NOT NULL DEFAULT 0,
+		track_id INTEGER NOT NULL DEFAULT 0,
+		vox_id INTEGER NOT NULL DEFAULT 0,
+		genre_id INTEGER NOT NULL DEFAULT 0,
+		min_tempo INTEGER NOT NULL DEFAULT 0,
+		max_tempo INTEGER NOT NULL DEFAULT 0
+	);
+`
+
+type Format struct {
+	Id   int64
+	Name string
+	Gap  int // seconds between songs
+	Sets []FormatSet
+}
+
+func (f Format) ProperName() string {
+	return toTitle(f.Name)
+}
+
+type FormatSet struct {
+	Id       int64
+	FormatId int64
+	SetNum   int
+	Name     string
+	Target   int // seconds, 0 for just the slots
+	Slots    []Slot
+}
+
+func (s FormatSet) ProperName() string {
+	return toTitle(s.Name)
+}
+
+func (s FormatSet) TargetLength() string {
+	return fmtDuration(int64(s.Target))
+}
+
+// Slot is a song a set needs, any id left at 0 doesn't matter
+type Slot struct {
+	Id       int64
+	SetId    int64
+	Name     string
+	AtEnd    bool  // after the filler rather than before
+	Track    Track // a particular song, just Id and Title
+	Vox      Vox
+	Genre    Genre
+	MinTempo int
+	MaxTempo int
+}
+
+func (s Slot) ProperName() string {
+	return toTitle(s.Name)
+}
+
+// Fits is true if the song can go in the slot
+func (s Slot) Fits(t Track) bool {
+	switch {
+	case s.Track.Id != 0:
+		return t.Id == s.Track.Id
+	case s.Vox.Id != 0 && t.Vox.Id != s.Vox.Id:
+		return false
+	case s.Genre.Id != 0 && t.Genre.Id != s.Genre.Id:
+		return false
+	case s.MinTempo != 0 && t.Tempo < s.MinTempo:
+		return false
+	case s.MaxTempo != 0 && (t.Tempo == 0 || t.Tempo > s.MaxTempo):
+		return false
+	}
+	return true
+}
+
+// Describe is what the slot needs, in words
+func (s Slot) Describe() string {
+	if s.Track.Id != 0 {
+		return s.Track.ProperTitle()
+	}
+	wants := []string{}
+	if s.Vox.Id != 0 {
+		wants = append(wants, "sung by "+s.Vox.ProperName())
+	}
+	if s.Genre.Id != 0 {
+		wants = append(wants, s.Genre.ProperName())
+	}
+	if s.MinTempo != 0 {
+		wants = append(wants, fmt.Sprintf("%d BPM or more", s.MinTempo))
+	}
+	if s.MaxTempo != 0 {
+		wants = append(wants, fmt.Sprintf("%d BPM or less", s.MaxTempo))
+	}
+	if len(wants) == 0 {
+		return "any song"
+	}
+	return strings.Join(wants, ", ")
+}
+
+// Fill makes a setlist from the format with songs from the catalog, and says
+// which slots nothing fit
+func (f Format) Fill(name string, catalog []Track, rnd *rand.Rand) (Setlist, []string) {
+	sl := Setlist{Name: name, Gap: f.Gap}
+	used := map[int64]bool{}
+	unfilled := []string{}
+	// a slot takes the first fit in a shuffled catalog
+	pick := func(slot Slot) (Track, bool) {
+		for _, i := range rnd.Perm(len(catalog)) {
+			t := catalog[i]
+			if !used[t.Id] && slot.Fits(t) {
+				used[t.Id] = true
+				return t, true
+			}
+		}
+		return Track{}, false
+	}
+	for _, fs := range f.Sets {
+		first, last := []Track{}, []Track{}
+		for _, slot := range fs.Slots {
+			t, ok := pick(slot)
+			if !ok {
+				unfilled = append(unfilled, fmt.Sprintf("%s: %s (%s)", fs.ProperName(), slot.ProperName(), slot.Describe()))
+				continue
+			}
+			if slot.AtEnd {
+				last = append(last, t)
+			} else {
+				first = append(first, t)
+			}
+		}
+		tracks := first
+		if left := fs.Target - setSecs(first, f.Gap) - setSecs(last, f.Gap); fs.Target > 0 && left > 0 {
+			pool := slices.DeleteFunc(slices.Clone(catalog), func(t Track) bool { return used[t.Id] })
+			filler := Generate(pool, GenOptions{Sets: 1, Target: left, Gap: f.Gap}, rnd).Sets[0].Tracks
+			for _, t := range filler {
+				used[t.Id] = true
+			}
+			tracks = append(tracks, filler...)
+		}
+		tracks = append(tracks, last...)
+		sl.Sets = append(sl.Sets, Set{SetNum: fs.SetNum, Name: fs.Name, Target: fs.Target, Tracks: tracks})
+	}
+	return sl, unfilled
+}
+
+// setSecs is the estimated running time of some songs
+func setSecs(tracks []Track, gap int) int {
+	secs := 0
+	for _, t := range tracks {
+		secs += estLength(t) + gap
+	}
+	return secs
+}
+
+func (d *DB) GetAllFormats() ([]Format, error) {
+	rows, err := d.db.Query("select id from format order by name;")
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	formats := []Format{}
+	for _, id := range ids {
+		f, err := d.GetFormat(id)
+		if err != nil {
+			return nil, err
+		}
+		formats = append(formats, f)
+	}
+	return formats, nil
+}
+
+func (d *DB) GetFormat(id int64) (Format, error) {
+	f := Format{Id: id, Sets: []FormatSet{}}
+	err := d.db.QueryRow("select name, gap from format where id=$1;", id).Scan(&f.Name, &f.Gap)
+	if err != nil {
+		return Format{}, err
+	}
+	q := "select id, setnum, name, target from format_set where format_id=$1 order by setnum;"
+	rows, err := d.db.Query(q, id)
+	if err != nil {
+		return Format{}, err
+	}
+	for rows.Next() {
+		fs := FormatSet{FormatId: id, Slots: []Slot{}}
+		err = rows.Scan(&fs.Id, &fs.SetNum, &fs.Name, &fs.Target)
+		if err != nil {
+			rows.Close()
+			return Format{}, err
+		}
+		f.Sets = append(f.Sets, fs)
+	}
+	rows.Close()
+
+	q = `
+select format_slot.id, format_slot.set_id, format_slot.name, format_slot.at_end,
+	format_slot.track_id, coalesce(track.title, ''), format_slot.vox_id, coalesce(vox.name, ''),
+	format_slot.genre_id, coalesce(genre.name, ''), format_slot.min_tempo, format_slot.max_tempo
+from format_slot
+join format_set on format_set.id = format_slot.set_id
+left join track on track.id = format_slot.track_id
+left join vox on vox.id = format_slot.vox_id
+left join genre on genre.id = format_slot.genre_id
+where format_set.format_id = $1
+order by format_slot.id;`
+	rows, err = d.db.Query(q, id)
+	if err != nil {
+		return Format{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s Slot
+		err = rows.Scan(&s.Id, &s.SetId, &s.Name, &s.AtEnd, &s.Track.Id, &s.Track.Title, &s.Vox.Id, &s.Vox.Name,
+			&s.Genre.Id, &s.Genre.Name, &s.MinTempo, &s.MaxTempo)
+		if err != nil {
+			return Format{}, err
+		}
+		for i := range f.Sets {
+			if f.Sets[i].Id == s.SetId {
+				f.Sets[i].Slots = append(f.Sets[i].Slots, s)
+			}
+		}
+	}
+	return f, rows.Err()
+}
+
+func (d *DB) AddFormat(f Format) (int64, error) {
+	res, err := d.db.Exec("insert into format (name, gap) values ($1, $2);", f.Name, f.Gap)
+	if err != nil {
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+// AddFormatSet puts a set at the end of the format
+func (d *DB) AddFormatSet(fs FormatSet) (int64, error) {
+	q := `
+insert into format_set (format_id, setnum, name, target)
+select $1, coalesce(max(setnum)+1, 0), $2, $3 from format_set where format_id=$1;`
+	res, err := d.db.Exec(q, fs.FormatId, fs.Name, fs.Target)
+	if err != nil {
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+func (d *DB) AddSlot(s Slot) (int64, error) {
+	q := `
+insert into format_slot (set_id, name, at_end, track_id, vox_id, genre_id, min_tempo, max_tempo)
+values ($1, $2, $3, $4, $5, $6, $7, $8);`
+	res, err := d.db.Exec(q, s.SetId, s.Name, s.AtEnd, s.Track.Id, s.Vox.Id, s.Genre.Id, s.MinTempo, s.MaxTempo)
+	if err != nil {
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+// DelFormatSet removes a set and its slots
+func (d *DB) DelFormatSet(id int64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		"delete from format_slot where set_id=$1;",
+		"delete from format_set where id=$1;",
+	} {
+		_, err = tx.Exec(q, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *DB) DelSlot(id int64) error {
+	_, err := d.db.Exec("delete from format_slot where id=$1;", id)
+	return err
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+// The setlist generator drafts a night from a pool of songs. Songs are dealt
+// out to the sets grouped by vocalist, era and genre so each set gets a share
+// of every group, then each set is ordered one song at a time picking the one
+// that costs least to follow the last: a kit change costs most, then the same
+// singer twice, then the same era or genre, and the tempo should follow the
+// shape of a set (up-tempo opener, a dip past the middle, the fastest to close).
+// Songs that segue into each other in a setlist stay together as a medley,
+// it's dealt and ordered as one.
+
+// GenOptions is what the draft should look like
+type GenOptions struct {
+	Name   string
+	Sets   int
+	Target int             // seconds per set, 0 to split the whole pool
+	Gap    int             // seconds between songs
+	Segues map[int64]int64 // song to the song it segues into, from GetSegues
+}
+
+// songs without a length are guessed at this for filling sets
+const guessLength = 4 * 60
+
+// costs of following one song with another
+const (
+	kitCost   = 4.0
+	voxCost   = 2.0
+	eraCost   = 1.0
+	genreCost = 1.0
+	tempoCost = 3.0
+)
+
+// tempoShape is where in the pool's tempo range (0 slowest, 1 fastest) a song
+// should be at a point through the set (0 first, 1 last)
+func tempoShape(x float64) float64 {
+	points := [][2]float64{{0, 0.8}, {0.6, 0.35}, {1, 1}}
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		if x <= b[0] {
+			return a[1] + (b[1]-a[1])*(x-a[0])/(b[0]-a[0])
+		}
+	}
+	return points[len(points)-1][1]
+}
+
+func estLength(t Track) int {
+	if t.Duration == 0 {
+		return guessLength
+	}
+	return t.Duration
+}
+
+// Generate drafts a setlist from the pool. rnd shuffles the pool first so
+// each run gives a different draft.
+func Generate(pool []Track, opt GenOptions, rnd *rand.Rand) Setlist {
+	if opt.Sets < 1 {
+		opt.Sets = 1
+	}
+	sl := Setlist{Name: opt.Name, Gap: opt.Gap, SetTarget: opt.Target}
+	units := medleys(pool, opt.Segues)
+	rnd.Shuffle(len(units), func(i, j int) { units[i], units[j] = units[j], units[i] })
+	slices.SortStableFunc(units, func(a, b []Track) int {
+		return cmp.Or(cmp.Compare(a[0].Vox.Id, b[0].Vox.Id), cmp.Compare(a[0].Era.Id, b[0].Era.Id), cmp.Compare(a[0].Genre.Id, b[0].Genre.Id))
+	})
+
+	// deal each song (or medley) to the shortest set it still fits in
+	dealt := make([][][]Track, opt.Sets)
+	secs := make([]int, opt.Sets)
+	for _, u := range units {
+		length := 0
+		for _, t := range u {
+			length += estLength(t)
+		}
+		to := -1
+		for i := range dealt {
+			if opt.Target > 0 && secs[i]+length > opt.Target {
+				continue
+			}
+			if to == -1 || secs[i] < secs[to] {
+				to = i
+			}
+		}
+		if to == -1 {
+			continue // too long for what's left anywhere
+		}
+		dealt[to] = append(dealt[to], u)
+		secs[to] += length + opt.Gap
+	}
+
+	slow, fast := tempoRange(pool)
+	for i, units := range dealt {
+		sl.Sets = append(sl.Sets, Set{
+			SetNum: i,
+			Name:   fmt.Sprintf("set %d", i+1),
+			Tracks: orderSet(units, slow, fast),
+		})
+	}
+	return sl
+}
+
+// medleys splits the pool into songs on their own and runs of songs that
+// segue one into the next, with Segue set on all but the last of a run
+func medleys(pool []Track, segues map[int64]int64) [][]Track {
+	byId := map[int64]Track{}
+	for _, t := range pool {
+		byId[t.Id] = t
+	}
+	into := map[int64]bool{}
+	for from, to := range segues {
+		_, a := byId[from]
+		_, b := byId[to]
+		into[to] = into[to] || (a && b)
+	}
+	used := map[int64]bool{}
+	chain := func(t Track) []Track {
+		unit := []Track{}
+		for !used[t.Id] {
+			used[t.Id] = true
+			next, ok := byId[segues[t.Id]]
+			t.Segue = ok && !used[next.Id]
+			unit = append(unit, t)
+			if !t.Segue {
+				break
+			}
+			t = next
+		}
+		return unit
+	}
+	units := [][]Track{}
+	for _, t := range pool {
+		if !into[t.Id] && !used[t.Id] {
+			units = append(units, chain(t))
+		}
+	}
+	// anything left segues round in a loop, break it anywhere
+	for _, t := range pool {
+		if !used[t.Id] {
+			units = append(units, chain(t))
+		}
+	}
+	return units
+}
+
+// tempoRange is the slowest and fastest known tempo
+func tempoRange(tracks []Track) (int, int) {
+	slow, fast := 0, 0
+	for _, t := range tracks {
+		if t.Tempo == 0 {
+			continue
+		}
+		if slow == 0 || t.Tempo < slow {
+			slow = t.Tempo
+		}
+		if t.Tempo > fast {
+			fast = t.Tempo
+		}
+	}
+	return slow, fast
+}
+
+// orderSet picks the cheapest song (or medley, by its first song) to go
+// next until they're all used
+func orderSet(units [][]Track, slow, fast int) []Track {
+	left := slices.Clone(units)
+	n := 0
+	for _, u := range units {
+		n += len(u)
+	}
+	ordered := []Track{}
+	for len(left) > 0 {
+		pos := 0.0
+		if n > 1 {
+			pos = float64(len(ordered)) / float64(n-1)
+		}
+		best, bestCost := 0, math.Inf(1)
+		for i, u := range left {
+			c := followCost(ordered, u[0], pos, slow, fast)
+			if c < bestCost {
+				best, bestCost = i, c
+			}
+		}
+		ordered = append(ordered, left[best]...)
+		left = slices.Delete(left, best, best+1)
+	}
+	return ordered
+}
+
+func followCost(before []Track, t Track, pos float64, slow, fast int) float64 {
+	c := 0.0
+	if t.Tempo != 0 && fast > slow {
+		tempo := float64(t.Tempo-slow) / float64(fast-slow)
+		c += tempoCost * math.Abs(tempo-tempoShape(pos))
+	}
+	if len(before) == 0 {
+		return c
+	}
+	prev := before[len(before)-1]
+	if prev.Kit.Id != t.Kit.Id {
+		c += kitCost
+	}
+	if prev.Vox.Id == t.Vox.Id {
+		c += voxCost
+	}
+	if prev.Era.Id == t.Era.Id {
+		c += eraCost
+	}
+	if prev.Genre.Id == t.Genre.Id {
+		c += genreCost
+	}
+	return c
+}
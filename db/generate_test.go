@@ -0,0 +1,142 @@
+package db
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// genPool is n songs of the given length, spread over a few singers, eras,
+// genres and kits, ids from 1
+func genPool(n, secs int) []Track {
+	pool := []Track{}
+	for i := range n {
+		pool = append(pool, Track{
+			Id:       int64(i + 1),
+			Duration: secs,
+			Tempo:    80 + i*7%60,
+			Vox:      Vox{Id: int64(i % 3)},
+			Era:      Era{Id: int64(i % 4)},
+			Genre:    Genre{Id: int64(i % 2)},
+			Kit:      Kit{Id: int64(i % 2)},
+		})
+	}
+	return pool
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pool    []Track
+		opt     GenOptions
+		sets    int
+		used    int       // songs dealt out
+		medleys [][]int64 // runs that must come out together, in order
+	}{
+		{"split the pool", genPool(10, 200), GenOptions{Sets: 3}, 3, 10, nil},
+		{"no sets is one", genPool(4, 200), GenOptions{}, 1, 4, nil},
+		{"fills to the target", genPool(12, 300), GenOptions{Sets: 2, Target: 1800}, 2, 12, nil},
+		{"leaves out what doesn't fit", genPool(12, 300), GenOptions{Sets: 2, Target: 1500, Gap: 20}, 2, 8, nil},
+		{"unknown lengths are guessed", genPool(6, 0), GenOptions{Sets: 2, Target: 2 * guessLength}, 2, 4, nil},
+		{"medley", genPool(10, 200), GenOptions{Sets: 2, Segues: map[int64]int64{3: 7, 7: 1}}, 2, 10, [][]int64{{3, 7, 1}}},
+		{"two medleys", genPool(10, 200), GenOptions{Sets: 3, Segues: map[int64]int64{2: 9, 4: 5, 5: 6}}, 3, 10, [][]int64{{2, 9}, {4, 5, 6}}},
+		{"segue out of the pool", genPool(5, 200), GenOptions{Sets: 2, Segues: map[int64]int64{2: 99}}, 2, 5, nil},
+		{"segue loop", genPool(5, 200), GenOptions{Sets: 1, Segues: map[int64]int64{2: 3, 3: 2}}, 1, 5, nil},
+		{"medley dealt whole", genPool(6, 300), GenOptions{Sets: 2, Target: 900, Segues: map[int64]int64{1: 2, 2: 3}}, 2, 6, [][]int64{{1, 2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := range int64(20) {
+				sl := Generate(tt.pool, tt.opt, rand.New(rand.NewSource(seed)))
+				if len(sl.Sets) != tt.sets {
+					t.Fatalf("seed %d: %d sets, want %d", seed, len(sl.Sets), tt.sets)
+				}
+				seen := map[int64]bool{}
+				for _, s := range sl.Sets {
+					if tt.opt.Target > 0 {
+						est := s
+						for i := range est.Tracks {
+							est.Tracks[i].Duration = estLength(est.Tracks[i])
+						}
+						if secs := sl.Timing(est).Secs; secs > tt.opt.Target {
+							t.Errorf("seed %d: %s runs %ds, over the %ds target", seed, s.Name, secs, tt.opt.Target)
+						}
+					}
+					for _, tr := range s.Tracks {
+						if seen[tr.Id] {
+							t.Errorf("seed %d: song %d dealt twice", seed, tr.Id)
+						}
+						seen[tr.Id] = true
+					}
+				}
+				if len(seen) != tt.used {
+					t.Errorf("seed %d: %d songs dealt, want %d", seed, len(seen), tt.used)
+				}
+				for _, m := range tt.medleys {
+					if !hasMedley(sl, m) {
+						t.Errorf("seed %d: medley %v broken up in %v", seed, m, setIds(sl))
+					}
+				}
+			}
+		})
+	}
+}
+
+// hasMedley is true if a set has the songs one after another, each but the last segueing
+func hasMedley(sl Setlist, ids []int64) bool {
+	for _, s := range sl.Sets {
+		for i, tr := range s.Tracks {
+			if tr.Id != ids[0] || i+len(ids) > len(s.Tracks) {
+				continue
+			}
+			run := s.Tracks[i : i+len(ids)]
+			for j, tr := range run {
+				if tr.Id != ids[j] || tr.Segue != (j < len(ids)-1) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func setIds(sl Setlist) [][]int64 {
+	sets := [][]int64{}
+	for _, s := range sl.Sets {
+		ids := []int64{}
+		for _, tr := range s.Tracks {
+			ids = append(ids, tr.Id)
+		}
+		sets = append(sets, ids)
+	}
+	return sets
+}
+
+func TestGenerateOrder(t *testing.T) {
+	// a kit change costs most, so a set with two of each kit plays them in pairs
+	pool := []Track{
+		{Id: 1, Duration: 200, Kit: Kit{Id: 1}, Vox: Vox{Id: 1}},
+		{Id: 2, Duration: 200, Kit: Kit{Id: 2}, Vox: Vox{Id: 2}},
+		{Id: 3, Duration: 200, Kit: Kit{Id: 1}, Vox: Vox{Id: 2}},
+		{Id: 4, Duration: 200, Kit: Kit{Id: 2}, Vox: Vox{Id: 1}},
+	}
+	for seed := range int64(20) {
+		sl := Generate(pool, GenOptions{Sets: 1}, rand.New(rand.NewSource(seed)))
+		kits := []int64{}
+		for _, tr := range sl.Sets[0].Tracks {
+			kits = append(kits, tr.Kit.Id)
+		}
+		changes := 0
+		for i := 1; i < len(kits); i++ {
+			if kits[i] != kits[i-1] {
+				changes++
+			}
+		}
+		if changes != 1 {
+			t.Errorf("seed %d: kits %v change %d times, want 1", seed, kits, changes)
+		}
+	}
+	if dip := tempoShape(0.6); dip >= tempoShape(0) || dip >= tempoShape(1) {
+		t.Errorf("tempo shape %v, %v, %v doesn't dip past the middle", tempoShape(0), dip, tempoShape(1))
+	}
+}
@@ -0,0 +1,318 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Gig is a running copy of a setlist and where we are in it. The sets are
+// copied so the gig can be edited on the fly without touching the setlist.
+//
+// Where we are is State plus CurSet/CurTrack:
+//
+//	GigStart  before the first song (backed up past it)
+//	OnTrack   showing Sets[CurSet].Tracks[CurTrack]
+//	SetEnd    the break after Sets[CurSet]
+//	GigEnd    after the last song
+//
+// Empty sets are skipped over, there is never a break after one unless a song
+// was dropped out from under it. An audible shows on top of any state and
+// leaves it alone.
+type Gig struct {
+	Id       int64
+	Name     string // comes from setlist name
+	CurSet   int    // index to current set
+	CurTrack int    // index to current track, only meaningful OnTrack
+	State    string
+	Sets     []Set
+	Audible  int64   // track called that isn't in the set, showing instead of CurSet/CurTrack
+	Audibles []int64 // every audible called, in order
+	Skipped  []int64 // tracks skipped over while running
+	Rev      int     // bumped on every change so screens know to reload
+	Leader   string  // device that moves the gig, "" lets anyone
+	Started  int64   // unix time the gig was launched
+	Since    int64   // unix time what's showing came up
+
+	stored gigStored // what's already in the db, so UpdateGig writes only the changes
+}
+
+type gigStored struct {
+	leader   string
+	skipped  int
+	audibles int
+	showing  showing          // logged as played once it's gone
+	edited   map[int]struct{} // sets changed by live edits
+	passed   int64            // song dropped or deferred, it wasn't played
+}
+
+// saved marks everything as written
+func (g *Gig) saved() {
+	g.stored = gigStored{leader: g.Leader, skipped: len(g.Skipped), audibles: len(g.Audibles), showing: g.showing()}
+}
+
+// edit marks a set as changed
+func (g *Gig) edit(set int) {
+	if g.stored.edited == nil {
+		g.stored.edited = map[int]struct{}{}
+	}
+	g.stored.edited[set] = struct{}{}
+}
+
+const (
+	GigStart string = "Beginning"
+	OnTrack  string = "Track"
+	SetEnd   string = "SetEnd"
+	GigEnd   string = "End"
+)
+
+// GigPos is a snapshot of where the gig is
+type GigPos struct {
+	State string
+	Set   int
+	Track int
+}
+
+func NewGig(setlist Setlist) *Gig {
+	g := &Gig{Name: setlist.Name, Started: time.Now().Unix()}
+	g.Since = g.Started
+	for _, s := range setlist.Sets {
+		// copy the tracks too, edits to the gig mustn't show up in the setlist
+		s.Tracks = append([]Track{}, s.Tracks...)
+		g.Sets = append(g.Sets, s)
+	}
+	if s := g.nextFilled(0); s >= 0 {
+		g.to(OnTrack, s, 0)
+	} else {
+		g.toEnd()
+	}
+	return g
+}
+
+func (g Gig) ProperName() string {
+	return toTitle(g.Name)
+}
+
+func (g Gig) StartedAt() string {
+	t := time.Unix(g.Started, 0)
+	return t.Local().Format("2 Jan 2006 - 15:04:05")
+}
+
+// Place says where the gig is, for listing gigs to resume
+func (g *Gig) Place() string {
+	switch g.State {
+	case GigStart:
+		return "Beginning of setlist"
+	case GigEnd:
+		return "End of setlist"
+	case SetEnd:
+		return "End of set " + g.Sets[g.CurSet].ProperName()
+	}
+	t, _ := g.Current()
+	return fmt.Sprintf("Set %s, song %d: %s", g.Sets[g.CurSet].ProperName(), g.CurTrack+1, t.ProperTitle())
+}
+
+func (g *Gig) Pos() GigPos {
+	return GigPos{State: g.State, Set: g.CurSet, Track: g.CurTrack}
+}
+
+func (g *Gig) to(state string, set, track int) GigPos {
+	g.State, g.CurSet, g.CurTrack = state, set, track
+	return g.Pos()
+}
+
+func (g *Gig) toEnd() GigPos {
+	return g.to(GigEnd, max(len(g.Sets)-1, 0), 0)
+}
+
+func (g *Gig) toLast(set int) GigPos {
+	return g.to(OnTrack, set, len(g.Sets[set].Tracks)-1)
+}
+
+// nextFilled is the first set from index 'from' on that has songs, -1 if none
+func (g *Gig) nextFilled(from int) int {
+	for s := max(from, 0); s < len(g.Sets); s++ {
+		if len(g.Sets[s].Tracks) > 0 {
+			return s
+		}
+	}
+	return -1
+}
+
+// prevFilled is the last set at or before index 'from' that has songs, -1 if none
+func (g *Gig) prevFilled(from int) int {
+	for s := min(from, len(g.Sets)-1); s >= 0; s-- {
+		if len(g.Sets[s].Tracks) > 0 {
+			return s
+		}
+	}
+	return -1
+}
+
+// IsOnTrack is true when a song from the set is showing (not an audible)
+func (g *Gig) IsOnTrack() bool {
+	return g.Audible == 0 && g.State == OnTrack
+}
+
+// Current is the set song showing, if any
+func (g *Gig) Current() (Track, bool) {
+	if g.State != OnTrack {
+		return Track{}, false
+	}
+	return g.Sets[g.CurSet].Tracks[g.CurTrack], true
+}
+
+// Upcoming is up to n songs following the current one in its set
+func (g *Gig) Upcoming(n int) []Track {
+	if g.State != OnTrack {
+		return nil
+	}
+	rest := g.Sets[g.CurSet].Tracks[g.CurTrack+1:]
+	return rest[:min(n, len(rest))]
+}
+
+// Next moves forward one step: song, break between sets, end of gig.
+// During an audible it just goes back to where we were.
+func (g *Gig) Next() GigPos {
+	if g.Audible != 0 {
+		g.Audible = 0
+		return g.Pos()
+	}
+	switch g.State {
+	case GigStart:
+		if s := g.nextFilled(0); s >= 0 {
+			return g.to(OnTrack, s, 0)
+		}
+		return g.toEnd()
+	case OnTrack:
+		if g.CurTrack+1 < len(g.Sets[g.CurSet].Tracks) {
+			return g.to(OnTrack, g.CurSet, g.CurTrack+1)
+		}
+		return g.afterSet(g.CurSet)
+	case SetEnd:
+		if s := g.nextFilled(g.CurSet + 1); s >= 0 {
+			return g.to(OnTrack, s, 0)
+		}
+		return g.toEnd()
+	}
+	return g.Pos()
+}
+
+// afterSet is the break after a set, or the end if nothing follows it
+func (g *Gig) afterSet(set int) GigPos {
+	if g.nextFilled(set+1) >= 0 {
+		return g.to(SetEnd, set, 0)
+	}
+	return g.toEnd()
+}
+
+// Prev moves back one step, the mirror of Next
+func (g *Gig) Prev() GigPos {
+	if g.Audible != 0 {
+		g.Audible = 0
+		return g.Pos()
+	}
+	switch g.State {
+	case OnTrack:
+		if g.CurTrack > 0 {
+			return g.to(OnTrack, g.CurSet, g.CurTrack-1)
+		}
+		if s := g.prevFilled(g.CurSet - 1); s >= 0 {
+			return g.to(SetEnd, s, 0)
+		}
+		return g.to(GigStart, 0, 0)
+	case SetEnd, GigEnd:
+		if s := g.prevFilled(g.CurSet); s >= 0 {
+			return g.toLast(s)
+		}
+		return g.to(GigStart, 0, 0)
+	}
+	return g.Pos()
+}
+
+// Goto jumps to any song, ending an audible
+func (g *Gig) Goto(set, track int) (GigPos, error) {
+	if set < 0 || set >= len(g.Sets) || track < 0 || track >= len(g.Sets[set].Tracks) {
+		return g.Pos(), fmt.Errorf("no song %d in set %d", track+1, set+1)
+	}
+	g.Audible = 0
+	return g.to(OnTrack, set, track), nil
+}
+
+// Live edits. They only apply while a set song is showing.
+
+// Skip moves on without playing the current song, it stays in the gig to come back to
+func (g *Gig) Skip() GigPos {
+	if t, ok := g.Current(); ok && g.Audible == 0 {
+		if !t.IsItem() {
+			g.Skipped = append(g.Skipped, t.Id)
+		}
+		return g.Next()
+	}
+	return g.Pos()
+}
+
+// Defer moves the current song to the end of its set, along with any it
+// segues into, the next one comes up. Songs of its medley already played stay
+// put and no longer segue into it.
+func (g *Gig) Defer() GigPos {
+	if !g.IsOnTrack() {
+		return g.Pos()
+	}
+	tracks := g.Sets[g.CurSet].Tracks
+	t := tracks[g.CurTrack]
+	start, end := segueGroup(tracks, g.CurTrack)
+	if start < g.CurTrack {
+		tracks[g.CurTrack-1].Segue = false
+	}
+	g.Sets[g.CurSet].Tracks = slices.Concat(tracks[:g.CurTrack], tracks[end:], tracks[g.CurTrack:end])
+	g.edit(g.CurSet)
+	g.stored.passed = t.Id
+	return g.Pos()
+}
+
+// Drop takes the current song out of the gig, whatever slides into its place comes up
+func (g *Gig) Drop() GigPos {
+	if !g.IsOnTrack() {
+		return g.Pos()
+	}
+	tracks := g.Sets[g.CurSet].Tracks
+	g.stored.passed = tracks[g.CurTrack].Id
+	g.Sets[g.CurSet].Tracks = append(tracks[:g.CurTrack], tracks[g.CurTrack+1:]...)
+	g.edit(g.CurSet)
+	if g.CurTrack < len(g.Sets[g.CurSet].Tracks) {
+		return g.Pos()
+	}
+	return g.afterSet(g.CurSet)
+}
+
+// SwapNext swaps the two songs after the current one, a medley swaps as one
+func (g *Gig) SwapNext() GigPos {
+	if !g.IsOnTrack() {
+		return g.Pos()
+	}
+	tracks := g.Sets[g.CurSet].Tracks
+	_, a := segueGroup(tracks, g.CurTrack)
+	if a >= len(tracks) {
+		return g.Pos()
+	}
+	_, b := segueGroup(tracks, a)
+	if b >= len(tracks) {
+		return g.Pos()
+	}
+	_, c := segueGroup(tracks, b)
+	g.Sets[g.CurSet].Tracks = slices.Concat(tracks[:a], tracks[b:c], tracks[a:b], tracks[c:])
+	g.edit(g.CurSet)
+	return g.Pos()
+}
+
+// CallAudible shows a song from outside the set, the position stays put
+func (g *Gig) CallAudible(track_id int64) {
+	g.Audible = track_id
+	g.Audibles = append(g.Audibles, track_id)
+}
+
+// EndAudible goes back to the planned position
+func (g *Gig) EndAudible() {
+	g.Audible = 0
+}
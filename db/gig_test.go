@@ -0,0 +1,179 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+// testGig is a gig over sets of the given sizes, song i of set s has id (s+1)*10+i+1
+func testGig(sizes ...int) *Gig {
+	sl := Setlist{Name: "test"}
+	for s, n := range sizes {
+		set := Set{SetNum: s, Tracks: []Track{}}
+		for i := 0; i < n; i++ {
+			set.Tracks = append(set.Tracks, Track{Id: int64((s+1)*10 + i + 1)})
+		}
+		sl.Sets = append(sl.Sets, set)
+	}
+	return NewGig(sl)
+}
+
+// steps, one letter each
+var gigSteps = map[rune]func(g *Gig){
+	'n': func(g *Gig) { g.Next() },
+	'p': func(g *Gig) { g.Prev() },
+	's': func(g *Gig) { g.Skip() },
+	'd': func(g *Gig) { g.Defer() },
+	'x': func(g *Gig) { g.Drop() },
+	'w': func(g *Gig) { g.SwapNext() },
+	'a': func(g *Gig) { g.CallAudible(99) },
+	'e': func(g *Gig) { g.EndAudible() },
+	'm': func(g *Gig) { g.Sets[g.CurSet].Tracks[g.CurTrack].Segue = true },
+}
+
+func TestGigSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		sets    []int
+		steps   string
+		want    GigPos
+		audible int64
+		tracks  []int64 // the songs of set want.Set afterwards, if checked
+		segues  []int64 // the songs of set want.Set that segue into the next, checked with tracks
+	}{
+		// moving through [2, 0, 3, 0]
+		{"start", []int{2, 0, 3, 0}, "", GigPos{OnTrack, 0, 0}, 0, nil, nil},
+		{"next song", []int{2, 0, 3, 0}, "n", GigPos{OnTrack, 0, 1}, 0, nil, nil},
+		{"last song to break", []int{2, 0, 3, 0}, "nn", GigPos{SetEnd, 0, 0}, 0, nil, nil},
+		{"break skips empty set", []int{2, 0, 3, 0}, "nnn", GigPos{OnTrack, 2, 0}, 0, nil, nil},
+		{"last song to end past empty set", []int{2, 0, 3, 0}, "nnnnnn", GigPos{GigEnd, 3, 0}, 0, nil, nil},
+		{"next at end stays", []int{2, 0, 3, 0}, "nnnnnnn", GigPos{GigEnd, 3, 0}, 0, nil, nil},
+		{"prev from end", []int{2, 0, 3, 0}, "nnnnnnp", GigPos{OnTrack, 2, 2}, 0, nil, nil},
+		{"prev to break", []int{2, 0, 3, 0}, "nnnp", GigPos{SetEnd, 0, 0}, 0, nil, nil},
+		{"prev from break", []int{2, 0, 3, 0}, "nnp", GigPos{OnTrack, 0, 1}, 0, nil, nil},
+		{"prev from first song", []int{2, 0, 3, 0}, "p", GigPos{GigStart, 0, 0}, 0, nil, nil},
+		{"prev from start stays", []int{2, 0, 3, 0}, "pp", GigPos{GigStart, 0, 0}, 0, nil, nil},
+		{"next from start", []int{2, 0, 3, 0}, "pn", GigPos{OnTrack, 0, 0}, 0, nil, nil},
+
+		// empty sets at the start and end, and no sets at all
+		{"empty first set", []int{0, 2}, "", GigPos{OnTrack, 1, 0}, 0, nil, nil},
+		{"prev past empty first set", []int{0, 2}, "p", GigPos{GigStart, 0, 0}, 0, nil, nil},
+		{"start past empty first set", []int{0, 2}, "pn", GigPos{OnTrack, 1, 0}, 0, nil, nil},
+		{"empty last set", []int{2, 0}, "nn", GigPos{GigEnd, 1, 0}, 0, nil, nil},
+		{"prev past empty last set", []int{2, 0}, "nnp", GigPos{OnTrack, 0, 1}, 0, nil, nil},
+		{"no sets", []int{}, "", GigPos{GigEnd, 0, 0}, 0, nil, nil},
+		{"no sets prev", []int{}, "p", GigPos{GigStart, 0, 0}, 0, nil, nil},
+		{"no sets prev next", []int{}, "pn", GigPos{GigEnd, 0, 0}, 0, nil, nil},
+		{"all sets empty", []int{0, 0}, "", GigPos{GigEnd, 1, 0}, 0, nil, nil},
+
+		// an audible shows on top of every state and leaves it alone
+		{"audible on song", []int{2, 3}, "na", GigPos{OnTrack, 0, 1}, 99, nil, nil},
+		{"audible on song, next", []int{2, 3}, "nan", GigPos{OnTrack, 0, 1}, 0, nil, nil},
+		{"audible on song, prev", []int{2, 3}, "nap", GigPos{OnTrack, 0, 1}, 0, nil, nil},
+		{"audible on song, done", []int{2, 3}, "nae", GigPos{OnTrack, 0, 1}, 0, nil, nil},
+		{"audible at start", []int{2, 3}, "pan", GigPos{GigStart, 0, 0}, 0, nil, nil},
+		{"audible at break", []int{2, 3}, "nnan", GigPos{SetEnd, 0, 0}, 0, nil, nil},
+		{"audible at end", []int{2}, "nnan", GigPos{GigEnd, 0, 0}, 0, nil, nil},
+		{"no live edits during audible", []int{3}, "asdxw", GigPos{OnTrack, 0, 0}, 99, []int64{11, 12, 13}, nil},
+
+		// live edits
+		{"skip moves on", []int{3}, "s", GigPos{OnTrack, 0, 1}, 0, []int64{11, 12, 13}, nil},
+		{"defer first", []int{3}, "d", GigPos{OnTrack, 0, 0}, 0, []int64{12, 13, 11}, nil},
+		{"defer last", []int{3}, "nnd", GigPos{OnTrack, 0, 2}, 0, []int64{11, 12, 13}, nil},
+		{"drop middle", []int{3}, "nx", GigPos{OnTrack, 0, 1}, 0, []int64{11, 13}, nil},
+		{"drop last to break", []int{2, 3}, "nx", GigPos{SetEnd, 0, 0}, 0, []int64{11}, nil},
+		{"drop last to end", []int{2}, "nx", GigPos{GigEnd, 0, 0}, 0, []int64{11}, nil},
+		{"drop only song leaves break", []int{1, 2}, "x", GigPos{SetEnd, 0, 0}, 0, []int64{}, nil},
+		{"prev past dropped set", []int{1, 2}, "xnp", GigPos{GigStart, 0, 0}, 0, nil, nil},
+		{"swap next two", []int{3}, "w", GigPos{OnTrack, 0, 0}, 0, []int64{11, 13, 12}, nil},
+		{"swap one left", []int{3}, "nw", GigPos{OnTrack, 0, 1}, 0, []int64{11, 12, 13}, nil},
+		{"swap none left", []int{3}, "nnw", GigPos{OnTrack, 0, 2}, 0, []int64{11, 12, 13}, nil},
+		{"no live edits at break", []int{1, 2}, "ndxw", GigPos{SetEnd, 0, 0}, 0, []int64{11}, nil},
+
+		// medleys, 'm' marks the song showing as segueing into the next
+		{"defer medley", []int{4}, "mnmp", GigPos{OnTrack, 0, 0}, 0, []int64{11, 12, 13, 14}, []int64{11, 12}},
+		{"defer medley head", []int{4}, "mnmpd", GigPos{OnTrack, 0, 0}, 0, []int64{14, 11, 12, 13}, []int64{11, 12}},
+		{"defer medley tail", []int{4}, "mnmnd", GigPos{OnTrack, 0, 2}, 0, []int64{11, 12, 14, 13}, []int64{11}},
+		{"defer medley middle", []int{4}, "mnmd", GigPos{OnTrack, 0, 1}, 0, []int64{11, 14, 12, 13}, []int64{12}},
+		{"swap medley", []int{4}, "nmpw", GigPos{OnTrack, 0, 0}, 0, []int64{11, 14, 12, 13}, []int64{12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := testGig(tt.sets...)
+			for _, step := range tt.steps {
+				gigSteps[step](g)
+			}
+			if got := g.Pos(); got != tt.want {
+				t.Errorf("after %q at %+v, want %+v", tt.steps, got, tt.want)
+			}
+			if g.Audible != tt.audible {
+				t.Errorf("after %q audible %d, want %d", tt.steps, g.Audible, tt.audible)
+			}
+			if tt.tracks == nil {
+				return
+			}
+			ids, segues := []int64{}, []int64{}
+			for _, tr := range g.Sets[tt.want.Set].Tracks {
+				ids = append(ids, tr.Id)
+				if tr.Segue {
+					segues = append(segues, tr.Id)
+				}
+			}
+			if !slices.Equal(ids, tt.tracks) {
+				t.Errorf("after %q set %d is %v, want %v", tt.steps, tt.want.Set, ids, tt.tracks)
+			}
+			if !slices.Equal(segues, tt.segues) {
+				t.Errorf("after %q set %d segues from %v, want %v", tt.steps, tt.want.Set, segues, tt.segues)
+			}
+		})
+	}
+}
+
+func TestGigGoto(t *testing.T) {
+	tests := []struct {
+		name       string
+		set, track int
+		want       GigPos
+		fails      bool
+	}{
+		{"song", 2, 1, GigPos{OnTrack, 2, 1}, false},
+		{"first song", 0, 0, GigPos{OnTrack, 0, 0}, false},
+		{"empty set", 1, 0, GigPos{SetEnd, 0, 0}, true},
+		{"past last song", 0, 2, GigPos{SetEnd, 0, 0}, true},
+		{"negative set", -1, 0, GigPos{SetEnd, 0, 0}, true},
+		{"negative song", 0, -1, GigPos{SetEnd, 0, 0}, true},
+		{"past last set", 3, 0, GigPos{SetEnd, 0, 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// from the break after the first set, during an audible
+			g := testGig(2, 0, 3)
+			g.Next()
+			g.Next()
+			g.CallAudible(99)
+			got, err := g.Goto(tt.set, tt.track)
+			if (err != nil) != tt.fails {
+				t.Fatalf("Goto(%d, %d) error %v, want failure %v", tt.set, tt.track, err, tt.fails)
+			}
+			if got != tt.want || g.Pos() != tt.want {
+				t.Errorf("Goto(%d, %d) at %+v, want %+v", tt.set, tt.track, got, tt.want)
+			}
+			if !tt.fails && g.Audible != 0 {
+				t.Errorf("Goto(%d, %d) left audible %d showing", tt.set, tt.track, g.Audible)
+			}
+		})
+	}
+}
+
+func TestGigSkipped(t *testing.T) {
+	g := testGig(3)
+	g.Skip()
+	g.Next()
+	g.Skip()
+	if want := []int64{11, 13}; !slices.Equal(g.Skipped, want) {
+		t.Errorf("skipped %v, want %v", g.Skipped, want)
+	}
+	if want := (GigPos{GigEnd, 0, 0}); g.Pos() != want {
+		t.Errorf("at %+v, want %+v", g.Pos(), want)
+	}
+}
@@ -2,12 +2,133 @@ package db
 
 import (
 	"bytes"
+	"cmp"
 	"crypto/rand"
+	"database/sql"
 	"encoding/gob"
 	"fmt"
 	"math/big"
+	"slices"
+	"time"
 )
 
+// A running gig is spread over a few tables:
+//
+//	gig           name, leader and start time
+//	gig_set       the gig's copy of each set
+//	gig_entry     the songs in those sets, in order (items that aren't songs
+//	              keep their title, length and notes here)
+//	gig_position  where the gig is, the only row a next/prev press writes
+//	gig_call      songs skipped and audibles called, in order
+var gigSchema string = `
+	create table if not exists gig (
+		id INTEGER primary key,
+		name TEXT NOT NULL,
+		leader TEXT NOT NULL DEFAULT '',
+		started INTEGER NOT NULL
+	);
+	create table if not exists gig_set (
+		gig_id INTEGER NOT NULL,
+		setnum INTEGER NOT NULL,
+		set_id INTEGER NOT NULL,
+		name TEXT NOT NULL
+	);
+	create table if not exists gig_entry (
+		gig_id INTEGER NOT NULL,
+		setnum INTEGER NOT NULL,
+		seq INTEGER NOT NULL,
+		track_id INTEGER NOT NULL,
+		title TEXT NOT NULL DEFAULT '',
+		duration INTEGER NOT NULL DEFAULT 0,
+		notes TEXT NOT NULL DEFAULT '',
+		segue INTEGER NOT NULL DEFAULT 0
+	);
+	create table if not exists gig_position (
+		gig_id INTEGER primary key,
+		state TEXT NOT NULL,
+		cur_set INTEGER NOT NULL,
+		cur_track INTEGER NOT NULL,
+		audible INTEGER NOT NULL DEFAULT 0,
+		rev INTEGER NOT NULL DEFAULT 0,
+		since INTEGER NOT NULL DEFAULT 0
+	);
+	create table if not exists gig_call (
+		gig_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		track_id INTEGER NOT NULL
+	);
+`
+
+const (
+	callSkipped string = "skipped"
+	callAudible string = "audible"
+)
+
+// migrateGigs moves gigs stored the old way (gob blobs in gig.obj) into the
+// tables, all at once so a failure leaves the old table as it was
+func (d *DB) migrateGigs() error {
+	var n int
+	q := "select count(*) from pragma_table_info('gig') where name = 'obj';"
+	err := d.db.QueryRow(q).Scan(&n)
+	if err != nil || n == 0 {
+		return err
+	}
+	fmt.Println("migrating gigs out of gob blobs")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("alter table gig rename to gig_gob;")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(gigSchema)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query("select obj from gig_gob;")
+	if err != nil {
+		return err
+	}
+	gigs := []Gig{}
+	for rows.Next() {
+		var objd []byte
+		err = rows.Scan(&objd)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		g := Gig{}
+		err = gob.NewDecoder(bytes.NewBuffer(objd)).Decode(&g)
+		if err != nil {
+			// not worth keeping the rest of the db from opening over
+			fmt.Println("dropping unreadable gig: ", err.Error())
+			continue
+		}
+		gigs = append(gigs, g)
+	}
+	rows.Close()
+	for _, g := range gigs {
+		if g.State == "" {
+			// stored before there was a state, pick up at the top of its set
+			g.State = OnTrack
+			if _, err := g.Goto(g.CurSet, 0); err != nil {
+				g.toEnd()
+			}
+		}
+		err = writeGig(tx, &g)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("drop table gig_gob;")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (d *DB) NewGig(sl Setlist) (*Gig, error) {
 	// generate key (rando)
 	id, err := rand.Int(rand.Reader, big.NewInt(0x7FFFFFFF))
@@ -16,82 +137,301 @@ func (d *DB) NewGig(sl Setlist) (*Gig, error) {
 	}
 	// create a new gig
 	g := NewGig(sl)
-	// GOBinate the gig
 	g.Id = id.Int64()
-	var buf bytes.Buffer
-	gob_g := gob.NewEncoder(&buf)
-	err = gob_g.Encode(g)
+	return g, d.insertGig(g)
+}
+
+// insertGig writes a whole gig
+func (d *DB) insertGig(g *Gig) error {
+	tx, err := d.db.Begin()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer tx.Rollback()
+	err = writeGig(tx, g)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err == nil {
+		g.saved()
+	}
+	return err
+}
+
+func writeGig(tx *sql.Tx, g *Gig) error {
+	q := "insert into gig (id, name, leader, started) values ($1, $2, $3, $4);"
+	_, err := tx.Exec(q, g.Id, g.Name, g.Leader, g.Started)
+	if err != nil {
+		return err
 	}
-	// store in db
-	q := "insert into gig (id, obj) values ($1, $2);"
-	_, err = d.db.Exec(q, id.Int64(), buf.Bytes())
+	for setnum, s := range g.Sets {
+		q = "insert into gig_set (gig_id, setnum, set_id, name) values ($1, $2, $3, $4);"
+		_, err = tx.Exec(q, g.Id, setnum, s.Id, s.Name)
+		if err != nil {
+			return err
+		}
+		err = writeGigEntries(tx, g, setnum)
+		if err != nil {
+			return err
+		}
+	}
+	q = "insert into gig_position (gig_id, state, cur_set, cur_track, audible, rev, since) values ($1, $2, $3, $4, $5, $6, $7);"
+	_, err = tx.Exec(q, g.Id, g.State, g.CurSet, g.CurTrack, g.Audible, g.Rev, g.Since)
+	if err != nil {
+		return err
+	}
+	return writeGigCalls(tx, g)
+}
 
-	//return it
-	return g, err
+// writeGigEntries replaces the songs of one of the gig's sets
+func writeGigEntries(tx *sql.Tx, g *Gig, setnum int) error {
+	q := "delete from gig_entry where gig_id=$1 and setnum=$2;"
+	_, err := tx.Exec(q, g.Id, setnum)
+	if err != nil {
+		return err
+	}
+	for seq, t := range g.Sets[setnum].Tracks {
+		item := Track{}
+		if t.IsItem() {
+			item = t
+		}
+		q = "insert into gig_entry (gig_id, setnum, seq, track_id, title, duration, notes, segue) values ($1, $2, $3, $4, $5, $6, $7, $8);"
+		_, err = tx.Exec(q, g.Id, setnum, seq, t.Id, item.Title, item.Duration, item.Notes, t.Segue)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeGigCalls adds the skips and audibles since the gig was last saved
+func writeGigCalls(tx *sql.Tx, g *Gig) error {
+	calls := []struct {
+		kind   string
+		tracks []int64
+	}{
+		{callSkipped, g.Skipped[g.stored.skipped:]},
+		{callAudible, g.Audibles[g.stored.audibles:]},
+	}
+	for _, c := range calls {
+		for _, tid := range c.tracks {
+			q := "insert into gig_call (gig_id, kind, track_id) values ($1, $2, $3);"
+			_, err := tx.Exec(q, g.Id, c.kind, tid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (d *DB) GetGig(id int64) (*Gig, error) {
-	fmt.Println("getGig: ", id)
-	q := "select obj from gig where id=$1;"
-	var (
-		objd []byte
-	)
-	err := d.db.QueryRow(q, id).Scan(&objd)
+	g := Gig{Id: id}
+	q := `
+select gig.name, gig.leader, gig.started,
+	gig_position.state, gig_position.cur_set, gig_position.cur_track, gig_position.audible, gig_position.rev,
+	gig_position.since
+from gig
+join gig_position on gig_position.gig_id = gig.id
+where gig.id = $1;`
+	err := d.db.QueryRow(q, id).Scan(&g.Name, &g.Leader, &g.Started,
+		&g.State, &g.CurSet, &g.CurTrack, &g.Audible, &g.Rev, &g.Since)
 	if err != nil {
 		return nil, err
 	}
-	buf := bytes.NewBuffer(objd)
-	g := Gig{}
-	gob_g := gob.NewDecoder(buf)
-	err = gob_g.Decode(&g)
-	if err == nil {
-		fmt.Printf("getGig: %s id %d set %d track %d\n", g.Name, g.Id, g.CurSet, g.CurTrack)
+
+	q = "select setnum, set_id, name from gig_set where gig_id=$1 order by setnum;"
+	rows, err := d.db.Query(q, id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		s := Set{Tracks: []Track{}}
+		err = rows.Scan(&s.SetNum, &s.Id, &s.Name)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		g.Sets = append(g.Sets, s)
+	}
+	rows.Close()
+
+	// just the songs in the gig, not the whole catalog
+	q = trackSelect + "join gig_entry on gig_entry.track_id = track.id where gig_entry.gig_id = $1;"
+	rows, err = d.db.Query(q, id)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := d.extractTracks(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	byId := map[int64]Track{}
+	for _, t := range tracks {
+		byId[t.Id] = t
 	}
+	q = "select setnum, track_id, title, duration, notes, segue from gig_entry where gig_id=$1 order by setnum, seq;"
+	rows, err = d.db.Query(q, id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			setnum   int
+			track_id int64
+			item     Track
+			segue    bool
+		)
+		err = rows.Scan(&setnum, &track_id, &item.Title, &item.Duration, &item.Notes, &segue)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t, ok := byId[track_id]
+		if track_id == 0 {
+			t = item
+		} else if !ok {
+			t = Track{Id: track_id}
+		}
+		t.Segue = segue
+		g.Sets[setnum].Tracks = append(g.Sets[setnum].Tracks, t)
+	}
+	rows.Close()
 
-	return &g, err
+	q = "select kind, track_id from gig_call where gig_id=$1 order by rowid;"
+	rows, err = d.db.Query(q, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			kind     string
+			track_id int64
+		)
+		err = rows.Scan(&kind, &track_id)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case callSkipped:
+			g.Skipped = append(g.Skipped, track_id)
+		case callAudible:
+			g.Audibles = append(g.Audibles, track_id)
+		}
+	}
+	g.saved()
+	return &g, rows.Err()
 }
 
+// GetAllGigs is every gig that hasn't been ended, newest first
+func (d *DB) GetAllGigs() ([]Gig, error) {
+	q := "select id from gig;"
+	rows, err := d.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	gigs := []Gig{}
+	for _, id := range ids {
+		g, err := d.GetGig(id)
+		if err != nil {
+			return nil, err
+		}
+		gigs = append(gigs, *g)
+	}
+	slices.SortFunc(gigs, func(a, b Gig) int { return cmp.Compare(b.Started, a.Started) })
+	return gigs, nil
+}
+
+// UpdateGig saves what changed since the gig was loaded. Moving around only
+// rewrites the position row (and logs the song that went), edits to a set
+// rewrite that set's entries.
 func (d *DB) UpdateGig(g *Gig) error {
-	var buf bytes.Buffer
-	fmt.Printf("updateGig:%s id %d set %d track %d\n", g.Name, g.Id, g.CurSet, g.CurTrack)
-	gob_g := gob.NewEncoder(&buf)
-	err := gob_g.Encode(*g)
+	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
-	q := "update gig set obj=$2 where id=$1;"
-	objd := buf.Bytes()
-	fmt.Println("length of objd (bytes)=", len(objd))
-	res, err := d.db.Exec(q, g.Id, objd)
-	nr, rerr := res.RowsAffected()
-	if rerr != nil {
-		fmt.Println("err RowsAffected: ", rerr.Error())
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	err = logShown(tx, g, now, false)
+	if err != nil {
+		return err
+	}
+	if g.showing() != g.stored.showing {
+		g.Since = now
+	}
+	q := "update gig_position set state=$2, cur_set=$3, cur_track=$4, audible=$5, rev=$6, since=$7 where gig_id=$1;"
+	_, err = tx.Exec(q, g.Id, g.State, g.CurSet, g.CurTrack, g.Audible, g.Rev, g.Since)
+	if err != nil {
+		return err
+	}
+	if g.Leader != g.stored.leader {
+		q = "update gig set leader=$2 where id=$1;"
+		_, err = tx.Exec(q, g.Id, g.Leader)
+		if err != nil {
+			return err
+		}
+	}
+	for setnum := range g.stored.edited {
+		err = writeGigEntries(tx, g, setnum)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeGigCalls(tx, g)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err == nil {
+		g.saved()
 	}
-	fmt.Println("rows affected: ", nr)
 	return err
 }
 
-func (d *DB) RemoveGig(id int64) error {
-	fmt.Println("removing gig ", id)
-	q := "delete from gig where id=$1;"
-	res, err := d.db.Exec(q, id)
-	nr, rerr := res.RowsAffected()
-	if rerr != nil {
-		fmt.Println("err RowsAffected", rerr.Error())
+// EndGig logs whatever is still showing and removes the gig, its history stays
+func (d *DB) EndGig(g *Gig) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
 	}
-	fmt.Println("rows affected: ", nr)
-	return err
+	defer tx.Rollback()
+	err = logShown(tx, g, time.Now().Unix(), true)
+	if err != nil {
+		return err
+	}
+	err = removeGig(tx, g.Id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (d *DB) CleanupGigs() error {
-	q := "delete from gig where 1"
-	res, err := d.db.Exec(q)
-	nr, rerr := res.RowsAffected()
-	if rerr != nil {
-		fmt.Println("err RowsAffected", rerr.Error())
+func removeGig(tx *sql.Tx, id int64) error {
+	for _, q := range []string{
+		"delete from gig where id=$1;",
+		"delete from gig_set where gig_id=$1;",
+		"delete from gig_entry where gig_id=$1;",
+		"delete from gig_position where gig_id=$1;",
+		"delete from gig_call where gig_id=$1;",
+	} {
+		_, err := tx.Exec(q, id)
+		if err != nil {
+			return err
+		}
 	}
-	fmt.Println("rows affected: ", nr)
-	return err
+	return nil
 }
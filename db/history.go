@@ -0,0 +1,240 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Performance history. Every song that leaves the gig screen is logged when
+// it goes, unless it was dropped or moved later in the set without playing.
+// Songs flipped past in under minPlayed seconds don't count as played, skips
+// are logged either way.
+
+var historySchema string = `
+	create table if not exists performance (
+		id INTEGER primary key,
+		gig_id INTEGER NOT NULL,
+		gig_name TEXT NOT NULL,
+		set_name TEXT NOT NULL,
+		track_id INTEGER NOT NULL,
+		started INTEGER NOT NULL,
+		ended INTEGER NOT NULL,
+		skipped INTEGER NOT NULL DEFAULT 0,
+		audible INTEGER NOT NULL DEFAULT 0
+	);
+`
+
+const minPlayed = 30
+
+type Performance struct {
+	Id      int64
+	GigId   int64
+	GigName string
+	SetName string
+	Track   Track // just Id and Title
+	Started int64
+	Ended   int64
+	Skipped bool
+	Audible bool
+}
+
+func (p Performance) ProperGigName() string {
+	return toTitle(p.GigName)
+}
+
+func (p Performance) ProperSetName() string {
+	return toTitle(p.SetName)
+}
+
+func (p Performance) StartedAt() string {
+	t := time.Unix(p.Started, 0)
+	return t.Local().Format("2 Jan 2006 - 15:04")
+}
+
+// Seconds the song was up
+func (p Performance) Seconds() int64 {
+	return p.Ended - p.Started
+}
+
+func (p Performance) Duration() string {
+	return fmtDuration(p.Seconds())
+}
+
+// fmtDuration is m:ss
+func fmtDuration(secs int64) string {
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+// showing is what's on the gig screen, logged as a performance once it goes
+type showing struct {
+	track   int64
+	set     string
+	audible bool
+}
+
+func (g *Gig) showing() showing {
+	set := ""
+	if g.CurSet < len(g.Sets) {
+		set = g.Sets[g.CurSet].Name
+	}
+	if g.Audible != 0 {
+		return showing{track: g.Audible, set: set, audible: true}
+	}
+	if t, ok := g.Current(); ok {
+		return showing{track: t.Id, set: set}
+	}
+	return showing{}
+}
+
+// logShown records what was showing when the gig was loaded, if it's gone now (or the gig is over)
+func logShown(tx *sql.Tx, g *Gig, now int64, over bool) error {
+	was := g.stored.showing
+	if was.track == 0 || (!over && was == g.showing()) {
+		return nil
+	}
+	if !was.audible && was.track == g.stored.passed {
+		return nil
+	}
+	skipped := false
+	for _, tid := range g.Skipped[g.stored.skipped:] {
+		skipped = skipped || (!was.audible && tid == was.track)
+	}
+	if !skipped && now-g.Since < minPlayed {
+		return nil
+	}
+	q := `insert into performance (gig_id, gig_name, set_name, track_id, started, ended, skipped, audible)
+	values ($1, $2, $3, $4, $5, $6, $7, $8);`
+	_, err := tx.Exec(q, g.Id, g.Name, was.set, was.track, g.Since, now, skipped, was.audible)
+	return err
+}
+
+var performanceSelect string = `
+select performance.id, performance.gig_id, performance.gig_name, performance.set_name,
+	performance.track_id, coalesce(track.title, ''), performance.started, performance.ended,
+	performance.skipped, performance.audible
+from performance
+left join track on track.id = performance.track_id
+`
+
+// GetPerformances is the whole history, latest first
+func (d *DB) GetPerformances() ([]Performance, error) {
+	q := performanceSelect + "order by performance.started desc, performance.id desc;"
+	rows, err := d.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractPerformances(rows)
+}
+
+// GetTrackPerformances is the history of one song, latest first
+func (d *DB) GetTrackPerformances(track_id int64) ([]Performance, error) {
+	q := performanceSelect + "where performance.track_id = $1 order by performance.started desc, performance.id desc;"
+	rows, err := d.db.Query(q, track_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractPerformances(rows)
+}
+
+func extractPerformances(rows *sql.Rows) ([]Performance, error) {
+	perfs := []Performance{}
+	for rows.Next() {
+		p := Performance{}
+		err := rows.Scan(&p.Id, &p.GigId, &p.GigName, &p.SetName, &p.Track.Id, &p.Track.Title,
+			&p.Started, &p.Ended, &p.Skipped, &p.Audible)
+		if err != nil {
+			return nil, err
+		}
+		perfs = append(perfs, p)
+	}
+	return perfs, rows.Err()
+}
+
+// PlayStats sums up the performances of a song. Skips don't count as plays.
+type PlayStats struct {
+	TrackId    int64
+	Played     int
+	LastPlayed int64 // unix time, 0 if never
+	AvgSecs    int64
+	GigsSince  int // gigs since it was last played, every gig if never
+}
+
+func (s PlayStats) LastPlayedAt() string {
+	if s.LastPlayed == 0 {
+		return "never"
+	}
+	return time.Unix(s.LastPlayed, 0).Local().Format("2 Jan 2006")
+}
+
+func (s PlayStats) AvgDuration() string {
+	if s.Played == 0 {
+		return "-"
+	}
+	return fmtDuration(s.AvgSecs)
+}
+
+// Due is true if the song sat out the last n gigs
+func (s PlayStats) Due(n int) bool {
+	return n > 0 && s.GigsSince >= n
+}
+
+// GetPlayStats has the stats of every song in the catalog, by track id
+func (d *DB) GetPlayStats() (map[int64]PlayStats, error) {
+	// gigs newest first, gigs[0] is the last one
+	q := "select gig_id from performance group by gig_id order by min(started) desc;"
+	rows, err := d.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	gigs := map[int64]int{}
+	for rows.Next() {
+		var gig_id int64
+		err = rows.Scan(&gig_id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		gigs[gig_id] = len(gigs)
+	}
+	rows.Close()
+
+	stats := map[int64]PlayStats{}
+	tracks, err := d.GetAllTracks()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tracks {
+		stats[t.Id] = PlayStats{TrackId: t.Id, GigsSince: len(gigs)}
+	}
+
+	q = `
+select track_id, gig_id, started, ended from performance
+where skipped = 0
+order by started asc;`
+	rows, err = d.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	total := map[int64]int64{}
+	for rows.Next() {
+		var track_id, gig_id, started, ended int64
+		err = rows.Scan(&track_id, &gig_id, &started, &ended)
+		if err != nil {
+			return nil, err
+		}
+		// oldest first, so the last one seen is the last played
+		total[track_id] += ended - started
+		s := stats[track_id]
+		s.TrackId = track_id
+		s.Played++
+		s.AvgSecs = total[track_id] / int64(s.Played)
+		s.LastPlayed = started
+		s.GigsSince = gigs[gig_id]
+		stats[track_id] = s
+	}
+	return stats, rows.Err()
+}
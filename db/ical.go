@@ -0,0 +1,123 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// iCalendar (RFC 5545) output for events, so they can be subscribed to from a
+// phone. The calendar entry starts at call time since that's when we need to
+// be there; the show start is in the description.
+
+// CalEntry is an event and the names of the sets on its setlist
+type CalEntry struct {
+	Event Event
+	Sets  []string
+}
+
+// how long an entry runs when we only know when it starts
+const calLength = 4 * time.Hour
+
+const icalStamp = "20060102T150405Z"
+
+func ICalendar(entries []CalEntry) string {
+	var b strings.Builder
+	line := func(s string) {
+		b.WriteString(foldLine(s))
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Noodlehead//Noodlizer//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:Noodlehead Gigs")
+	now := time.Now().UTC().Format(icalStamp)
+	for _, c := range entries {
+		e := c.Event
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:event-%d@noodlizer", e.Id))
+		line("DTSTAMP:" + now)
+		begin := e.CallTime
+		if begin == "" {
+			begin = e.StartTime
+		}
+		if t, err := e.At(begin); begin != "" && err == nil {
+			line("DTSTART:" + t.UTC().Format(icalStamp))
+			line("DTEND:" + t.Add(calLength).UTC().Format(icalStamp))
+		} else if d, err := time.Parse(DateFormat, e.Date); err == nil {
+			// no times yet, all day
+			line("DTSTART;VALUE=DATE:" + d.Format("20060102"))
+			line("DTEND;VALUE=DATE:" + d.AddDate(0, 0, 1).Format("20060102"))
+		}
+		summary := e.ProperName()
+		if e.Venue.Name != "" {
+			summary += " @ " + e.Venue.ProperName()
+			loc := e.Venue.ProperName()
+			if e.Venue.Address != "" {
+				loc += ", " + e.Venue.Address
+			}
+			line("LOCATION:" + icalText(loc))
+		}
+		line("SUMMARY:" + icalText(summary))
+		line("DESCRIPTION:" + icalText(calDescription(c)))
+		if begin != "" {
+			// an hour's warning before call
+			line("BEGIN:VALARM")
+			line("ACTION:DISPLAY")
+			line("DESCRIPTION:" + icalText(summary))
+			line("TRIGGER:-PT1H")
+			line("END:VALARM")
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+func calDescription(c CalEntry) string {
+	e := c.Event
+	desc := []string{}
+	if e.CallTime != "" {
+		desc = append(desc, "Call: "+e.CallTime)
+	}
+	if e.StartTime != "" {
+		desc = append(desc, "Start: "+e.StartTime)
+	}
+	if e.Setlist != "" {
+		desc = append(desc, "Setlist: "+e.ProperSetlist())
+	}
+	for i, s := range c.Sets {
+		desc = append(desc, fmt.Sprintf("  %d. %s", i+1, toTitle(s)))
+	}
+	if e.Contact != "" {
+		desc = append(desc, "Contact: "+e.Contact)
+	}
+	if e.Notes != "" {
+		desc = append(desc, e.Notes)
+	}
+	return strings.Join(desc, "\n")
+}
+
+// icalText escapes a TEXT value
+func icalText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// foldLine ends a content line with CRLF, folding it at 75 octets without splitting a character
+func foldLine(s string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		l := len(string(r))
+		if n+l > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += l
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
@@ -2,40 +2,106 @@ package db
 
 import (
 	"fmt"
+	"html"
 	"html/template"
 	"strings"
-	"text/scanner"
 )
 
 // mark is a rudimentary markup. Tags:
 //
-// |<color>| where <color> is an HTML color (css notation)
+// |<color>| where <color> is a color class name (red, blue...), the span runs to the next '|'
 // {<note>} adds a <note> associated with the current line
+// [<chord>] places <chord> above the syllable that follows it
+// #<label> on a line by itself starts a section (#verse, #chorus, #bridge...)
+// "<label> x<n>" (n up to 9) or "repeat <label>" on a line by itself repeats a section declared above,
+// "repeat #<label>" too and it is reported when there is no such section
 //
-// May add more schtuff later. For now, parse each line into separate table row with notes in adjacent col
+// A section runs until the next section label or repeat line. "x<n>" is how many times
+// the section is sung in all, so "chorus x2" right after the chorus adds one more
+// and anywhere else adds two. Repeats are either collapsed to a single marker row
+// or expanded in place, see MarkText.Expand.
+//
+// The parser lives in markparse.go and the plain text, JSON and ANSI renderers in
+// markrender.go. PrettyText renders each line into separate table row with notes in adjacent col
 
 type MarkText struct {
-	TextLines []string
+	Doc    *Doc
+	Expand bool // write repeated sections out in full instead of a marker row
+}
+
+// Section is a heading in the rendered lyrics, Anchor is the element id to jump to
+type Section struct {
+	Label  string
+	Anchor string
+}
+
+func (s Section) ProperName() string {
+	return toTitle(s.Label)
 }
 
 func NewMarkText(raw string) *MarkText {
-	i := 0
-	tl := []string{}
-	for l := range strings.Lines(raw) {
-		//fmt.Println(i, l)
-		tl = append(tl, l)
-		i++
-	}
-	return &MarkText{TextLines: tl}
+	return &MarkText{Doc: ParseMark(raw)}
+}
+
+// Diagnostics are the syntax problems found while parsing
+func (m *MarkText) Diagnostics() []Diagnostic {
+	return m.Doc.Diags
 }
 
 const (
-	Text      string = "TEXT"
-	Color     string = "COLOR"
-	Note      string = "NOTE"
-	ColorText string = "COLORTEXT"
+	Text    string = "TEXT"
+	Color   string = "COLOR"
+	Note    string = "NOTE"
+	Chord   string = "CHORD"
+	Heading string = "HEADING"
+	Repeat  string = "REPEAT"
 )
 
+// layout resolves repeats, either leaving the Repeat line or writing the section out again
+func (m *MarkText) layout() []Line {
+	lines := []Line{}
+	sections := map[string][]Line{}
+	cur := ""
+	for _, l := range m.Doc.Lines {
+		switch l.Kind {
+		case Heading:
+			cur = l.Label
+			sections[cur] = []Line{}
+		case Repeat:
+			copies := l.Count
+			if cur == l.Label {
+				// the section just above is the first time through
+				copies--
+			}
+			cur = ""
+			if m.Expand {
+				for range copies {
+					lines = append(lines, Line{Kind: Heading, Num: l.Num, Label: l.Label})
+					lines = append(lines, sections[l.Label]...)
+				}
+				continue
+			}
+		default:
+			if cur != "" {
+				sections[cur] = append(sections[cur], l)
+			}
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// Sections lists the headings in the order they are rendered
+func (m *MarkText) Sections() []Section {
+	secs := []Section{}
+	for _, l := range m.layout() {
+		if l.Kind == Heading {
+			secs = append(secs, Section{Label: l.Label, Anchor: fmt.Sprintf("section-%d", len(secs))})
+		}
+	}
+	return secs
+}
+
 // TODO: make this configurable?
 type Row struct {
 	Text  string
@@ -48,98 +114,40 @@ type Col struct {
 const ROWS = 30
 
 func (m *MarkText) PrettyText(rows_per_col int) template.HTML {
-	s := scanner.Scanner{}
 	var b strings.Builder
 	cols := []Col{}
-	state := Text
-	//last_state := TEXT
 	b.WriteString("<table class='lyrics'>")
 	cidx := 0
-	//cols = append(cols, Col{Text: []string{}, Notes: []string{}})
 	cols = append(cols, Col{[]Row{}})
+	lines := m.layout()
 	if rows_per_col == 0 {
-		rows_per_col = (len(m.TextLines) + 1) / 2
+		rows_per_col = (len(lines) + 1) / 2
 	}
-	for i, l := range m.TextLines {
-		// search for tags
-		s.Init(strings.NewReader(l))
-		s.Mode ^= scanner.ScanChars | scanner.ScanStrings
-		//fmt.Println("line: ", i)
-		open_span := false
-		//b.WriteString("<tr><td class='lyrics'>")
-		//cols[cidx].Text = append(cols[cidx].Text, "<tr><td class='lyrics'>")
-		//ridx := len(cols[cidx].Text)
-		row_text := "<td class='lyrics'>"
-		note := ""
-
-		for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
-			switch tok {
-			case '|':
-				switch state {
-				case ColorText:
-					if open_span {
-						//b.WriteString("</span>")
-						row_text += "</span>"
-						open_span = false
-					}
-					fallthrough
-				case Text:
-					state = Color
-				case Color:
-					state = ColorText
-				}
-				continue
-			case '{':
-				state = Note
-				continue
-			case '}':
-				state = Text
-				continue
+	nsec := 0
+	for i, l := range lines {
+		row := Row{Notes: "<td class='note'></td>\n"}
+		switch l.Kind {
+		case Heading:
+			row.Text = fmt.Sprintf("<td class='section' id='section-%d'>%s</td>\n", nsec, html.EscapeString(toTitle(l.Label)))
+			nsec++
+		case Repeat:
+			row.Text = fmt.Sprintf("<td class='repeat'>%s &times;%d</td>\n", html.EscapeString(toTitle(l.Label)), l.Count)
+		default:
+			var text, note strings.Builder
+			chorded := htmlNodes(&text, &note, l.Nodes)
+			class := "lyrics"
+			if chorded {
+				class = "lyrics chorded"
 			}
-
-			//fmt.Println(state)
-			switch state {
-			case Text:
-				fallthrough
-			case ColorText:
-				//b.WriteString(s.TokenText())
-				row_text += s.TokenText()
-				if s.Peek() == ' ' {
-					//b.WriteRune(' ')
-					row_text += " "
-				}
-			case Note:
-				note += s.TokenText()
-				if s.Peek() == ' ' {
-					note += " "
-				}
-			case Color:
-				//b.WriteString("<span style='color:")
-				//b.WriteString(s.TokenText())
-				//b.WriteString(";'>")
-				row_text += fmt.Sprintf("<span class='%s'>", s.TokenText())
-				open_span = true
-			}
-			//fmt.Printf("%s %s: %s\n", state, s.Position, s.TokenText())
-		}
-		if open_span {
-			//b.WriteString("</span>")
-			row_text += "<span>"
-			open_span = false
+			row.Text = fmt.Sprintf("<td class='%s'>%s&nbsp;</td>\n", class, text.String())
+			row.Notes = fmt.Sprintf("<td class='note'>%s</td>\n", note.String())
 		}
-		//b.WriteString("</td>")
-		row_text += "&nbsp;</td>\n"
-		note = fmt.Sprintf("<td class='note'>%s</td>\n", note)
-		//b.WriteString(fmt.Sprintf("<td class='note'>%s</td></tr>", note))
-		cols[cidx].Row = append(cols[cidx].Row, Row{row_text, note})
-		//cols[cidx].Notes = append(cols[cidx].Notes, note)
+		cols[cidx].Row = append(cols[cidx].Row, row)
 		if i%rows_per_col == (rows_per_col - 1) {
-			//fmt.Println("new col")
 			cols = append(cols, Col{[]Row{}})
 			cidx += 1
 		}
 	}
-	//fmt.Println("Number of columns: ", len(cols))
 	max_rows := len(cols[0].Row)
 	for r := 0; r < max_rows; r++ {
 		b.WriteString("<tr>")
@@ -154,3 +162,50 @@ func (m *MarkText) PrettyText(rows_per_col int) template.HTML {
 	b.WriteString("</table>")
 	return template.HTML(b.String())
 }
+
+// htmlNodes writes the line text and notes, returns true if there were chords.
+// Everything that came from the user is escaped, and a color only becomes a
+// class if it is a plain name (see validColor).
+func htmlNodes(text, note *strings.Builder, nodes []Node) bool {
+	chorded := false
+	for _, n := range nodes {
+		switch n.Kind {
+		case Text:
+			text.WriteString(html.EscapeString(n.Value))
+		case Color:
+			valid := validColor(n.Value)
+			if valid {
+				text.WriteString(fmt.Sprintf("<span class='%s'>", n.Value))
+			}
+			if htmlNodes(text, note, n.Children) {
+				chorded = true
+			}
+			if valid {
+				text.WriteString("</span>")
+			}
+		case Note:
+			if note.Len() > 0 {
+				note.WriteString(" ")
+			}
+			note.WriteString(html.EscapeString(n.Value))
+		case Chord:
+			// zero width anchor, the name floats above whatever comes next
+			text.WriteString(fmt.Sprintf("<span class='chord'><span class='chord-name'>%s</span></span>", html.EscapeString(n.Value)))
+			chorded = true
+		}
+	}
+	return chorded
+}
+
+// validColor allows lower case names like "red" or "dark-blue", nothing that could escape an attribute
+func validColor(c string) bool {
+	if c == "" || c[0] < 'a' || c[0] > 'z' {
+		return false
+	}
+	for _, r := range c {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMarkRepeat(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		count int      // of the repeat line, 0 for none
+		want  []string // expanded lines, headings as #label
+	}{
+		{"x2 after its section is twice in all", "#chorus\nla\nchorus x2\n", 2, []string{"#chorus", "la", "#chorus", "la"}},
+		{"x2 later is twice more", "#chorus\nla\n#verse\noh\nchorus x2\n", 2, []string{"#chorus", "la", "#verse", "oh", "#chorus", "la", "#chorus", "la"}},
+		{"x1 after its section adds nothing", "#chorus\nla\nchorus x1\n", 1, []string{"#chorus", "la"}},
+		{"repeat after its section", "#chorus\nla\nrepeat chorus\n", 2, []string{"#chorus", "la", "#chorus", "la"}},
+		{"repeat later", "#chorus\nla\n#verse\noh\nrepeat chorus\n", 1, []string{"#chorus", "la", "#verse", "oh", "#chorus", "la"}},
+		{"repeat marked", "#chorus\nla\n#verse\noh\nRepeat #Chorus\n", 1, []string{"#chorus", "la", "#verse", "oh", "#chorus", "la"}},
+		{"second repeat in a row", "#chorus\nla\nchorus x2\nrepeat chorus\n", 1, []string{"#chorus", "la", "#chorus", "la", "#chorus", "la"}},
+		{"repeat lyric", "#verse\nrepeat after me\n", 0, []string{"#verse", "repeat after me"}},
+		{"unknown section", "#verse\noh\nrepeat chorus\nchorus x2\n", 0, []string{"#verse", "oh", "repeat chorus", "chorus x2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMarkText(tt.raw)
+			count := 0
+			for _, l := range m.Doc.Lines {
+				if l.Kind == Repeat {
+					count = l.Count
+				}
+			}
+			if count != tt.count {
+				t.Errorf("repeat count %d, want %d", count, tt.count)
+			}
+			m.Expand = true
+			got := []string{}
+			for _, fl := range m.Flat() {
+				if fl.Kind == "heading" {
+					got = append(got, "#"+fl.Label)
+				} else {
+					got = append(got, fl.Text)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expanded to\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parser for the mark lyric markup (see mark.go for the tags).
+//
+// The parse is lossless: every character of the raw text ends up in the Raw
+// field of exactly one node (or in a line's EOL), so Doc.Source() gives back
+// the original text. Problems are reported as diagnostics rather than guessed
+// at silently, and the parser always produces something renderable.
+
+// Node is a piece of a lyric line
+type Node struct {
+	Kind     string // Text, Color, Note or Chord
+	Raw      string // source text, markup characters included
+	Value    string // the text, color name, note or chord name
+	Col      int    // 1 based column (in runes) where the node starts
+	Children []Node // contents of a Color span
+	Closed   bool   // Color, Note and Chord: the closing character was found
+}
+
+// Line is one line of lyrics
+type Line struct {
+	Kind  string // Text, Heading or Repeat
+	Num   int    // 1 based line number in the source
+	Nodes []Node // for Heading and Repeat lines a single Text node with the whole line
+	EOL   string // line ending, if any
+	Label string // Heading and Repeat: the section
+	Count int    // Repeat: how many times the section is sung there, counting it once more if the line ends that section
+}
+
+// Diagnostic is a syntax problem at a line and column (both 1 based)
+type Diagnostic struct {
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+	Msg  string `json:"msg"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("line %d, col %d: %s", d.Line, d.Col, d.Msg)
+}
+
+// Doc is a parsed chunk of mark text
+type Doc struct {
+	Lines []Line
+	Diags []Diagnostic
+}
+
+// Source reassembles the raw text the Doc was parsed from
+func (d *Doc) Source() string {
+	var b strings.Builder
+	for _, l := range d.Lines {
+		for _, n := range l.Nodes {
+			b.WriteString(n.Raw)
+		}
+		b.WriteString(l.EOL)
+	}
+	return b.String()
+}
+
+// ParseMark parses raw mark text. It never fails, problems end up in Doc.Diags.
+func ParseMark(raw string) *Doc {
+	d := &Doc{}
+	sections := map[string]struct{}{}
+	cur := "" // section the line is in, a repeat line ends it
+	num := 0
+	for l := range strings.Lines(raw) {
+		num++
+		body, eol := splitEOL(l)
+		line := Line{Kind: Text, Num: num, EOL: eol}
+		whole := []Node{}
+		if body != "" {
+			whole = append(whole, Node{Kind: Text, Raw: body, Value: body, Col: 1})
+		}
+
+		trimmed := strings.TrimSpace(body)
+		if label, ok := parseHeading(trimmed); ok {
+			sections[label] = struct{}{}
+			cur = label
+			line.Kind, line.Label, line.Nodes = Heading, label, whole
+		} else if label, n, marked, ok := parseRepeat(trimmed); ok {
+			_, known := sections[label]
+			switch {
+			case !known:
+				// "repeat the night away" is a lyric, only "repeat #x" surely meant a section
+				if marked {
+					d.diag(num, 1, fmt.Sprintf("repeat of unknown section %q", label))
+				}
+				line.Nodes = d.parseInline(num, body)
+			case n > maxRepeat:
+				d.diag(num, 1, fmt.Sprintf("repeat count %d is more than %d, left as text", n, maxRepeat))
+				line.Nodes = d.parseInline(num, body)
+			default:
+				if n == 0 {
+					// "repeat <label>" is once more, twice in all right after the section
+					n = 1
+					if label == cur {
+						n = 2
+					}
+				}
+				cur = ""
+				line.Kind, line.Label, line.Count, line.Nodes = Repeat, label, n, whole
+			}
+		} else {
+			line.Nodes = d.parseInline(num, body)
+		}
+		d.Lines = append(d.Lines, line)
+	}
+	return d
+}
+
+func (d *Doc) diag(line, col int, msg string) {
+	d.Diags = append(d.Diags, Diagnostic{Line: line, Col: col, Msg: msg})
+}
+
+func splitEOL(l string) (string, string) {
+	switch {
+	case strings.HasSuffix(l, "\r\n"):
+		return l[:len(l)-2], "\r\n"
+	case strings.HasSuffix(l, "\n"):
+		return l[:len(l)-1], "\n"
+	}
+	return l, ""
+}
+
+// "#chorus" on its own
+func parseHeading(l string) (string, bool) {
+	if len(l) < 2 || l[0] != '#' || len(strings.Fields(l)) != 1 {
+		return "", false
+	}
+	return strings.ToLower(l[1:]), true
+}
+
+// maxRepeat is the most times a section can be repeated, a count past it is
+// more likely a typo than a song, and it'd be expanded in full
+const maxRepeat = 9
+
+// "chorus x2", "repeat chorus" or "repeat #chorus". The count is 0 for the
+// repeat forms, marked is true for "repeat #chorus".
+func parseRepeat(l string) (string, int, bool, bool) {
+	f := strings.Fields(strings.ToLower(l))
+	switch {
+	case len(f) == 2 && f[0] == "repeat":
+		label, marked := strings.CutPrefix(f[1], "#")
+		return label, 0, marked, label != ""
+	case len(f) == 2 && strings.HasPrefix(f[1], "x"):
+		n, err := strconv.Atoi(f[1][1:])
+		if err == nil && n > 0 {
+			return f[0], n, false, true
+		}
+	}
+	return "", 0, false, false
+}
+
+// inline markup scanner for a single line. It scans runes but slices Raw out
+// of the line by byte offset, so invalid UTF-8 comes back out as it went in.
+type lineParser struct {
+	d     *Doc
+	num   int
+	body  string
+	src   []rune
+	offs  []int // byte offset of each rune in body, and of the end
+	pos   int
+	tpos  int // rune where pending plain text starts
+	tnext bool
+}
+
+func (d *Doc) parseInline(num int, body string) []Node {
+	p := &lineParser{d: d, num: num, body: body}
+	for i, r := range body {
+		p.src = append(p.src, r)
+		p.offs = append(p.offs, i)
+	}
+	p.offs = append(p.offs, len(body))
+	nodes, _ := p.nodes(false)
+	return nodes
+}
+
+func (p *lineParser) col() int {
+	return p.pos + 1
+}
+
+// raw is the source text of runes [start, end)
+func (p *lineParser) raw(start, end int) string {
+	return p.body[p.offs[start]:p.offs[end]]
+}
+
+func (p *lineParser) addText() {
+	if !p.tnext {
+		p.tpos, p.tnext = p.pos, true
+	}
+}
+
+func (p *lineParser) flush(nodes []Node) []Node {
+	if !p.tnext {
+		return nodes
+	}
+	p.tnext = false
+	t := p.raw(p.tpos, p.pos)
+	return append(nodes, Node{Kind: Text, Raw: t, Value: t, Col: p.tpos + 1})
+}
+
+// until returns the text up to (not including) the next stop rune, its length
+// in runes and whether it was found
+func (p *lineParser) until(start int, stop rune) (string, int, bool) {
+	for i := start; i < len(p.src); i++ {
+		if p.src[i] == stop {
+			return p.raw(start, i), i - start, true
+		}
+	}
+	return p.raw(start, len(p.src)), len(p.src) - start, false
+}
+
+// nodes parses to the end of the line, or to the closing '|' when inSpan
+func (p *lineParser) nodes(inSpan bool) ([]Node, bool) {
+	nodes := []Node{}
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		switch r {
+		case '|':
+			if inSpan {
+				nodes = p.flush(nodes)
+				p.pos++
+				return nodes, true
+			}
+			name, n, ok := p.until(p.pos+1, '|')
+			switch {
+			case !ok:
+				p.d.diag(p.num, p.col(), "color name is missing its closing '|'")
+			case strings.TrimSpace(name) == "":
+				// both bars are text, the closing one mustn't open a span of its own
+				p.d.diag(p.num, p.col(), "empty color name")
+				p.addText()
+				p.pos += n + 2
+				continue
+			default:
+				nodes = p.flush(nodes)
+				start := p.pos
+				p.pos += n + 2
+				children, closed := p.nodes(true)
+				span := Node{Kind: Color, Value: strings.ToLower(strings.TrimSpace(name)), Col: start + 1, Children: children, Closed: closed}
+				span.Raw = p.raw(start, p.pos)
+				if !validColor(span.Value) {
+					p.d.diag(p.num, start+1, fmt.Sprintf("%q is not a color name", span.Value))
+				}
+				if !closed {
+					p.d.diag(p.num, start+1, fmt.Sprintf("|%s| span is not closed with '|'", span.Value))
+				}
+				nodes = append(nodes, span)
+				continue
+			}
+		case '{', '[':
+			kind, stop := Note, '}'
+			if r == '[' {
+				kind, stop = Chord, ']'
+			}
+			val, n, ok := p.until(p.pos+1, stop)
+			if !ok {
+				p.d.diag(p.num, p.col(), fmt.Sprintf("'%c' is not closed with '%c'", r, stop))
+			}
+			if kind == Chord && (!ok || strings.TrimSpace(val) == "") {
+				// not a chord, leave it be as text
+				break
+			}
+			nodes = p.flush(nodes)
+			start := p.pos
+			p.pos += n + 1
+			if ok {
+				p.pos++
+			}
+			nodes = append(nodes, Node{Kind: kind, Raw: p.raw(start, p.pos), Value: strings.TrimSpace(val), Col: start + 1, Closed: ok})
+			continue
+		case '}', ']':
+			p.d.diag(p.num, p.col(), fmt.Sprintf("unmatched '%c'", r))
+		}
+		p.addText()
+		p.pos++
+	}
+	return p.flush(nodes), false
+}
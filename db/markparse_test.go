@@ -0,0 +1,71 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseMarkSource(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"empty", ""},
+		{"plain", "la la la\nsecond line"},
+		{"trailing newline", "la\n"},
+		{"crlf", "#verse\r\nla [G]la\r\n\r\nverse x2\r\n"},
+		{"mixed endings", "a\r\nb\nc\r\n"},
+		{"lone cr", "a\rb\n"},
+		{"spaces kept", "  la   la  \t\n"},
+		{"markup", "|red|la {soft}|  [Am]la [C/G] end\n"},
+		{"invalid utf-8", "\xfb"},
+		{"invalid utf-8 in markup", "a\xfbb |red|x\xff|y {n\xfd} [C\xfe]é\xc3"},
+		{"unclosed chord", "la [G la\n"},
+		{"unclosed note", "la {soft\n"},
+		{"unclosed color", "|red| la\n|blue la\n"},
+		{"empty markup", "|| [] {}\n"},
+		{"unmatched", "la ] la } la\n"},
+		{"headings", "#verse\n  #chorus  \n# not\n#\n"},
+		{"repeats", "#chorus\nla\nchorus x2\nrepeat chorus\nrepeat #bridge\nverse x99\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMark(tt.raw).Source(); got != tt.raw {
+				t.Errorf("ParseMark(%q).Source() = %q", tt.raw, got)
+			}
+		})
+	}
+}
+
+func TestParseMarkDiags(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string // as Diagnostic.String
+	}{
+		{"clean", "#chorus\nla |red|la| [G]la {soft}\nchorus x2\n", []string{}},
+		{"empty color", "a || b\n", []string{"line 1, col 3: empty color name"}},
+		{"empty color then span", "|| |red|la|\n", []string{"line 1, col 1: empty color name"}},
+		{"bad color name", "|dark red|la|\n", []string{`line 1, col 1: "dark red" is not a color name`}},
+		{"unclosed color name", "la |red\n", []string{"line 1, col 4: color name is missing its closing '|'"}},
+		{"unclosed span", "|red|la\n", []string{"line 1, col 1: |red| span is not closed with '|'"}},
+		{"unclosed chord", "la [G la\n", []string{"line 1, col 4: '[' is not closed with ']'"}},
+		{"unclosed note", "é {soft\n", []string{"line 1, col 3: '{' is not closed with '}'"}},
+		{"unmatched", "la ]\n}\n", []string{"line 1, col 4: unmatched ']'", "line 2, col 1: unmatched '}'"}},
+		{"unknown section", "#verse\nrepeat #chorus\n", []string{`line 2, col 1: repeat of unknown section "chorus"`}},
+		{"unknown section unmarked", "#verse\nrepeat chorus\nchorus x2\n", []string{}},
+		{"repeat over max", "#chorus\nla\nchorus x10\n", []string{"line 3, col 1: repeat count 10 is more than 9, left as text"}},
+		{"repeat at max", "#chorus\nla\nchorus x9\n", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, d := range ParseMark(tt.raw).Diags {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseMark(%q) diagnostics %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Renderers besides PrettyText. They all work off the same parse (see
+// markparse.go) flattened into FlatLines: the plain text of a line plus where
+// the notes, chords and colors go.
+
+// ChordAt is a chord and the rune offset in the line text it sits above
+type ChordAt struct {
+	Chord string `json:"chord"`
+	At    int    `json:"at"`
+}
+
+// ColorRun colors the runes Start up to (not including) End of the line text
+type ColorRun struct {
+	Color string `json:"color"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// FlatLine is a rendered line with the markup taken out
+type FlatLine struct {
+	Kind   string     `json:"kind"` // text, heading or repeat
+	Text   string     `json:"text"`
+	Label  string     `json:"label,omitempty"`
+	Count  int        `json:"count,omitempty"`
+	Notes  []string   `json:"notes,omitempty"`
+	Chords []ChordAt  `json:"chords,omitempty"`
+	Colors []ColorRun `json:"colors,omitempty"`
+}
+
+// Flat lays out the lines (honoring Expand) and strips the markup
+func (m *MarkText) Flat() []FlatLine {
+	flat := []FlatLine{}
+	for _, l := range m.layout() {
+		fl := FlatLine{Kind: strings.ToLower(l.Kind), Label: l.Label, Count: l.Count}
+		if l.Kind == Text {
+			var b strings.Builder
+			flattenNodes(&fl, &b, l.Nodes)
+			fl.Text = b.String()
+		}
+		flat = append(flat, fl)
+	}
+	return flat
+}
+
+func flattenNodes(fl *FlatLine, b *strings.Builder, nodes []Node) {
+	for _, n := range nodes {
+		at := len([]rune(b.String()))
+		switch n.Kind {
+		case Text:
+			b.WriteString(n.Value)
+		case Color:
+			flattenNodes(fl, b, n.Children)
+			if validColor(n.Value) {
+				fl.Colors = append(fl.Colors, ColorRun{Color: n.Value, Start: at, End: len([]rune(b.String()))})
+			}
+		case Note:
+			fl.Notes = append(fl.Notes, n.Value)
+		case Chord:
+			fl.Chords = append(fl.Chords, ChordAt{Chord: n.Value, At: at})
+		}
+	}
+}
+
+// PlainText is the lyrics with the markup stripped, notes tacked on the end of their line
+func (m *MarkText) PlainText() string {
+	var b strings.Builder
+	for _, fl := range m.Flat() {
+		switch fl.Kind {
+		case "heading":
+			b.WriteString(fmt.Sprintf("[%s]", toTitle(fl.Label)))
+		case "repeat":
+			b.WriteString(fmt.Sprintf("(%s x%d)", toTitle(fl.Label), fl.Count))
+		default:
+			b.WriteString(fl.Text)
+			if len(fl.Notes) > 0 {
+				b.WriteString("  -- " + strings.Join(fl.Notes, " "))
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// JSON has the flattened lines and the parse diagnostics
+func (m *MarkText) JSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Lines       []FlatLine   `json:"lines"`
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}{Lines: m.Flat(), Diagnostics: m.Diagnostics()})
+}
+
+// SGR codes for the color class names in style.css (black is the normal text color there)
+var ansiColors = map[string]string{
+	"black":  "39",
+	"red":    "91",
+	"green":  "92",
+	"yellow": "93",
+	"blue":   "94",
+	"purple": "95",
+	"cyan":   "96",
+	"white":  "97",
+}
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiChord  = "\x1b[1;33m"
+	ansiHead   = "\x1b[1;4;33m"
+	ansiRepeat = "\x1b[3;33m"
+	ansiNote   = "\x1b[2m"
+)
+
+// ANSI renders for a terminal, chords on their own line above the text like a chord sheet
+func (m *MarkText) ANSI() string {
+	var b strings.Builder
+	for _, fl := range m.Flat() {
+		switch fl.Kind {
+		case "heading":
+			b.WriteString(ansiHead + toTitle(fl.Label) + ansiReset + "\n")
+			continue
+		case "repeat":
+			b.WriteString(ansiRepeat + fmt.Sprintf("%s x%d", toTitle(fl.Label), fl.Count) + ansiReset + "\n")
+			continue
+		}
+		if len(fl.Chords) > 0 {
+			b.WriteString(ansiChord + chordLine(fl.Chords) + ansiReset + "\n")
+		}
+		b.WriteString(ansiLine(fl))
+		if len(fl.Notes) > 0 {
+			b.WriteString("  " + ansiNote + strings.Join(fl.Notes, " ") + ansiReset)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// chordLine spaces the chords out over their syllables, pushing right when they would collide
+func chordLine(chords []ChordAt) string {
+	line := []rune{}
+	for _, c := range chords {
+		at := c.At
+		if len(line) > 0 && at <= len(line) {
+			at = len(line) + 1
+		}
+		for len(line) < at {
+			line = append(line, ' ')
+		}
+		line = append(line, []rune(c.Chord)...)
+	}
+	return string(line)
+}
+
+// ansiLine is the line text with color escapes (runs never overlap, a '|' ends the span)
+func ansiLine(fl FlatLine) string {
+	if len(fl.Colors) == 0 {
+		return fl.Text
+	}
+	var b strings.Builder
+	cur := ""
+	for i, r := range []rune(fl.Text) {
+		want := ""
+		for _, c := range fl.Colors {
+			if i >= c.Start && i < c.End {
+				want = ansiColors[c.Color]
+			}
+		}
+		if want != cur {
+			if want == "" {
+				b.WriteString(ansiReset)
+			} else {
+				b.WriteString("\x1b[" + want + "m")
+			}
+			cur = want
+		}
+		b.WriteRune(r)
+	}
+	if cur != "" {
+		b.WriteString(ansiReset)
+	}
+	return b.String()
+}
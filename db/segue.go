@@ -0,0 +1,123 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Segues. An entry marked Segue runs straight into the one after it without
+// stopping, and a run of them (a medley) is a group that moves as one:
+// reordering a set, deferring or swapping at a gig and the generator all keep
+// it together. To take a song out of a medley, unmark the segue first.
+
+// segueGroup is the entries [start, end) that segue together with tracks[i]
+func segueGroup(tracks []Track, i int) (int, int) {
+	start, end := i, i+1
+	for start > 0 && tracks[start-1].Segue {
+		start--
+	}
+	for end < len(tracks) && tracks[end-1].Segue {
+		end++
+	}
+	return start, end
+}
+
+// SegueInto is the entry the one at i segues into, if it does
+func (s Set) SegueInto(i int) (Track, bool) {
+	if i < 0 || i+1 >= len(s.Tracks) || !s.Tracks[i].Segue {
+		return Track{}, false
+	}
+	return s.Tracks[i+1], true
+}
+
+// ToggleSegue marks or unmarks an entry as running into the next one
+func (d *DB) ToggleSegue(sid int64, entry int64) error {
+	q := "update sets_tracks set segue = not segue where set_id=$1 and id=$2;"
+	res, err := d.db.Exec(q, sid, entry)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no entry %d in set %d", entry, sid)
+	}
+	return nil
+}
+
+// MoveEntry moves an entry, and anything it segues with, up or down past
+// the entry (or group) next to it
+func (d *DB) MoveEntry(sid int64, entry int64, up bool) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("select id, segue from sets_tracks where set_id=$1 order by seq, id;", sid)
+	if err != nil {
+		return err
+	}
+	tracks := []Track{}
+	for rows.Next() {
+		var t Track
+		err = rows.Scan(&t.Entry, &t.Segue)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		tracks = append(tracks, t)
+	}
+	rows.Close()
+	i := slices.IndexFunc(tracks, func(t Track) bool { return t.Entry == entry })
+	if i == -1 {
+		return fmt.Errorf("no entry %d in set %d", entry, sid)
+	}
+	start, end := segueGroup(tracks, i)
+	var moved []Track
+	switch {
+	case up && start > 0:
+		prev, _ := segueGroup(tracks, start-1)
+		moved = slices.Concat(tracks[:prev], tracks[start:end], tracks[prev:start], tracks[end:])
+	case !up && end < len(tracks):
+		_, next := segueGroup(tracks, end)
+		moved = slices.Concat(tracks[:start], tracks[end:next], tracks[start:end], tracks[next:])
+	default:
+		return nil // already at the top or bottom
+	}
+	for seq, t := range moved {
+		_, err = tx.Exec("update sets_tracks set seq=$1 where id=$2;", seq, t.Entry)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetSegues is every song that segues into another somewhere in the
+// setlists, to the song it goes into
+func (d *DB) GetSegues() (map[int64]int64, error) {
+	q := "select set_id, track_id, segue from sets_tracks order by set_id, seq, id;"
+	rows, err := d.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	segues := map[int64]int64{}
+	var (
+		lastSet int64 = -1
+		last    Track
+	)
+	for rows.Next() {
+		var (
+			sid int64
+			t   Track
+		)
+		err = rows.Scan(&sid, &t.Id, &t.Segue)
+		if err != nil {
+			return nil, err
+		}
+		if sid == lastSet && last.Segue && !last.IsItem() && !t.IsItem() {
+			segues[last.Id] = t.Id
+		}
+		lastSet, last = sid, t
+	}
+	return segues, rows.Err()
+}
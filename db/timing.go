@@ -0,0 +1,122 @@
+package db
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Running-time estimates. A set runs for the length of its songs plus the
+// setlist's gap between each of them (none after a segue), items like a
+// raffle count for their length if they have one. Songs without a length are
+// counted so the estimate can say it's short.
+
+// DefaultGap is the seconds between songs for a new setlist
+const DefaultGap = 20
+
+// how far under the target a set can run before it's flagged
+const underSlack = 5 * 60
+
+type SetTime struct {
+	Secs    int // estimated seconds
+	Unknown int // songs with no length
+	Target  int // seconds, 0 for none
+}
+
+// Timing estimates one of the setlist's sets
+func (sl Setlist) Timing(s Set) SetTime {
+	t := SetTime{Target: cmp.Or(s.Target, sl.SetTarget)}
+	for i, tr := range s.Tracks {
+		if i > 0 && !s.Tracks[i-1].Segue {
+			t.Secs += sl.Gap
+		}
+		if tr.Duration == 0 && !tr.IsItem() {
+			t.Unknown++
+		}
+		t.Secs += tr.Duration
+	}
+	return t
+}
+
+// TotalTime is all the sets, without the breaks between them
+func (sl Setlist) TotalTime() SetTime {
+	total := SetTime{}
+	for _, s := range sl.Sets {
+		t := sl.Timing(s)
+		total.Secs += t.Secs
+		total.Unknown += t.Unknown
+	}
+	return total
+}
+
+// TargetMinutes is the set target as entered on the setlist form
+func (sl Setlist) TargetMinutes() int {
+	return sl.SetTarget / 60
+}
+
+// TargetMinutes is the set's own target as entered on the set form
+func (s Set) TargetMinutes() int {
+	return s.Target / 60
+}
+
+// Length is the song's m:ss, "" if it isn't known
+func (t Track) Length() string {
+	if t.Duration == 0 {
+		return ""
+	}
+	return fmtDuration(int64(t.Duration))
+}
+
+func (t SetTime) Length() string {
+	return fmtDuration(int64(t.Secs))
+}
+
+func (t SetTime) TargetLength() string {
+	return fmtDuration(int64(t.Target))
+}
+
+// Status is "over" or "under" the target, "ok" inside it, "" with no target
+func (t SetTime) Status() string {
+	switch {
+	case t.Target == 0:
+		return ""
+	case t.Secs > t.Target:
+		return "over"
+	case t.Secs < t.Target-underSlack:
+		return "under"
+	}
+	return "ok"
+}
+
+// Warning says how far off the target the set is, if it is
+func (t SetTime) Warning() string {
+	switch t.Status() {
+	case "over":
+		return fmt.Sprintf("%s over the %s target", fmtDuration(int64(t.Secs-t.Target)), t.TargetLength())
+	case "under":
+		return fmt.Sprintf("%s under the %s target", fmtDuration(int64(t.Target-t.Secs)), t.TargetLength())
+	}
+	return ""
+}
+
+// ParseLength reads a song length as m:ss (or plain seconds), "" is 0
+func ParseLength(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	m, sec, found := strings.Cut(s, ":")
+	if !found {
+		m, sec = "0", s
+	}
+	mins, err := strconv.Atoi(m)
+	if err != nil || mins < 0 {
+		return 0, fmt.Errorf("length %q isn't m:ss", s)
+	}
+	secs, err := strconv.Atoi(sec)
+	if err != nil || secs < 0 || (found && secs > 59) {
+		return 0, fmt.Errorf("length %q isn't m:ss", s)
+	}
+	return mins*60 + secs, nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import "testing"
+
+func TestParseLength(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  int
+		fails bool
+	}{
+		{"3:30", 210, false},
+		{"210", 210, false},
+		{" 4:05 ", 245, false},
+		{"0:59", 59, false},
+		{"12:00", 720, false},
+		{"", 0, false},
+		{"3:60", 0, true},
+		{"3:", 0, true},
+		{":30", 0, true},
+		{"-1:30", 0, true},
+		{"3:-5", 0, true},
+		{"-20", 0, true},
+		{"three", 0, true},
+		{"3.5", 0, true},
+		{"1:2:3", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLength(tt.in)
+			if (err != nil) != tt.fails {
+				t.Fatalf("ParseLength(%q) error %v, want failure %v", tt.in, err, tt.fails)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLength(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTiming(t *testing.T) {
+	song := func(secs int) Track { return Track{Id: 1, Duration: secs} }
+	segue := func(secs int) Track { return Track{Id: 1, Duration: secs, Segue: true} }
+	raffle := Track{Title: "raffle", Duration: 120}
+	tests := []struct {
+		name      string
+		setTarget int // the setlist's
+		set       Set
+		want      SetTime
+		status    string
+	}{
+		{"empty", 0, Set{}, SetTime{}, ""},
+		{"gaps between songs", 0, Set{Tracks: []Track{song(200), song(100), song(60)}}, SetTime{Secs: 400}, ""},
+		{"no gap after a segue", 0, Set{Tracks: []Track{segue(200), song(100), song(60)}}, SetTime{Secs: 380}, ""},
+		{"unknown lengths", 0, Set{Tracks: []Track{song(200), song(0), {Title: "break"}}}, SetTime{Secs: 240, Unknown: 1}, ""},
+		{"item counts", 0, Set{Tracks: []Track{song(200), raffle}}, SetTime{Secs: 340}, ""},
+		{"setlist target", 600, Set{Tracks: []Track{song(580)}}, SetTime{Secs: 580, Target: 600}, "ok"},
+		{"own target over", 600, Set{Target: 300, Tracks: []Track{song(580)}}, SetTime{Secs: 580, Target: 300}, "over"},
+		{"own target without setlist's", 0, Set{Target: 900, Tracks: []Track{song(580)}}, SetTime{Secs: 580, Target: 900}, "under"},
+		{"under inside slack", 600, Set{Tracks: []Track{song(300)}}, SetTime{Secs: 300, Target: 600}, "ok"},
+		{"under past slack", 600, Set{Tracks: []Track{song(299)}}, SetTime{Secs: 299, Target: 600}, "under"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl := Setlist{Gap: 20, SetTarget: tt.setTarget, Sets: []Set{tt.set}}
+			got := sl.Timing(tt.set)
+			if got != tt.want {
+				t.Errorf("Timing = %+v, want %+v", got, tt.want)
+			}
+			if got.Status() != tt.status {
+				t.Errorf("Status = %q, want %q", got.Status(), tt.status)
+			}
+		})
+	}
+}
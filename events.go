@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"noodlizer/db"
+)
+
+// Event and venue handlers. An event is a date we're playing, at a venue,
+// with the setlist we'll launch the gig from.
+
+func (v *View) ShowEvents(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Show Events.")
+	events, err := v.db.GetAllEvents()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	// split at today, past ones latest first
+	today := time.Now().Format(db.DateFormat)
+	data := struct {
+		Upcoming []db.Event
+		Past     []db.Event
+	}{Upcoming: []db.Event{}, Past: []db.Event{}}
+	for _, e := range events {
+		if e.Date >= today {
+			data.Upcoming = append(data.Upcoming, e)
+		} else {
+			data.Past = append([]db.Event{e}, data.Past...)
+		}
+	}
+	err = v.index.ExecuteTemplate(w, "events.tmpl", data)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+// calEntries adds the set names from each event's setlist
+func (v *View) calEntries(events []db.Event) ([]db.CalEntry, error) {
+	entries := []db.CalEntry{}
+	for _, e := range events {
+		c := db.CalEntry{Event: e}
+		if e.SetlistId != 0 {
+			sl, err := v.db.GetSetlist(e.SetlistId)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range sl.Sets {
+				c.Sets = append(c.Sets, s.Name)
+			}
+		}
+		entries = append(entries, c)
+	}
+	return entries, nil
+}
+
+func writeCalendar(w http.ResponseWriter, entries []db.CalEntry) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	io.WriteString(w, db.ICalendar(entries))
+}
+
+// EventsCalendar is the upcoming events as a calendar to subscribe to
+func (v *View) EventsCalendar(w http.ResponseWriter, r *http.Request) {
+	events, err := v.db.GetUpcomingEvents()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entries, err := v.calEntries(events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeCalendar(w, entries)
+}
+
+// EventCalendar is one event to download into a calendar
+func (v *View) EventCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e, err := v.db.GetEvent(int64(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	entries, err := v.calEntries([]db.Event{e})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="event-%d.ics"`, id))
+	writeCalendar(w, entries)
+}
+
+func (v *View) ShowEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Show Event ", id)
+	e, err := v.db.GetEvent(int64(id))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.index.ExecuteTemplate(w, "event.tmpl", e)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+// the event form needs the venues and setlists to pick from
+type eventForm struct {
+	Event    db.Event
+	Venues   []db.Venue
+	Setlists []db.Setlist
+	Action   string
+}
+
+func (v *View) renderEventForm(w http.ResponseWriter, e db.Event, action string) {
+	venues, err := v.db.GetAllVenues()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	setlists, err := v.db.GetAllSetlists()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.index.ExecuteTemplate(w, "edit_event.tmpl", eventForm{Event: e, Venues: venues, Setlists: setlists, Action: action})
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+func (v *View) CreateEvent(w http.ResponseWriter, r *http.Request) {
+	e := db.Event{Date: time.Now().Format(db.DateFormat)}
+	v.renderEventForm(w, e, "save")
+}
+
+func (v *View) EditEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Edit Event ", id)
+	e, err := v.db.GetEvent(int64(id))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	v.renderEventForm(w, e, "update")
+}
+
+// eventFromForm reads the event form, adding the venue if a new one was typed in
+func (v *View) eventFromForm(r *http.Request) (db.Event, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return db.Event{}, err
+	}
+	e := db.Event{
+		Name:      strings.TrimSpace(r.PostFormValue("Name")),
+		Date:      strings.TrimSpace(r.PostFormValue("Date")),
+		CallTime:  strings.TrimSpace(r.PostFormValue("CallTime")),
+		StartTime: strings.TrimSpace(r.PostFormValue("StartTime")),
+		Contact:   strings.TrimSpace(r.PostFormValue("Contact")),
+		Notes:     strings.TrimSpace(r.PostFormValue("Notes")),
+	}
+	if e.Name == "" {
+		return e, errors.New("the event needs a name")
+	}
+	if _, err = time.Parse(db.DateFormat, e.Date); err != nil {
+		return e, fmt.Errorf("date %q isn't YYYY-MM-DD", e.Date)
+	}
+	for _, t := range []string{e.CallTime, e.StartTime} {
+		if _, err = time.Parse(db.TimeFormat, t); t != "" && err != nil {
+			return e, fmt.Errorf("time %q isn't HH:MM", t)
+		}
+	}
+	e.SetlistId, _ = strconv.ParseInt(r.PostFormValue("SetlistId"), 10, 64)
+	e.Venue.Id, _ = strconv.ParseInt(r.PostFormValue("VenueId"), 10, 64)
+	if name := strings.TrimSpace(r.PostFormValue("NewVenue")); name != "" {
+		e.Venue.Id, err = v.db.AddVenue(db.Venue{Name: name})
+		if err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+func (v *View) SaveEvent(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Saving (new) Event")
+	e, err := v.eventFromForm(r)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	id, err := v.db.AddEvent(e)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/event/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) UpdateEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Updating event ", id)
+	e, err := v.eventFromForm(r)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	e.Id = int64(id)
+	err = v.db.UpdateEvent(e)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/event/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) ShowVenues(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Show Venues.")
+	venues, err := v.db.GetAllVenues()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	data := struct {
+		Venues []db.Venue
+		New    db.Venue // blank for the add form
+	}{Venues: venues}
+	err = v.index.ExecuteTemplate(w, "venues.tmpl", data)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+func (v *View) ShowVenue(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Show Venue ", id)
+	venue, err := v.db.GetVenue(int64(id))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	events, err := v.db.GetVenueEvents(venue.Id)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	data := struct {
+		db.Venue
+		Events []db.Event
+	}{venue, events}
+	err = v.index.ExecuteTemplate(w, "venue.tmpl", data)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+func venueFromForm(r *http.Request) (db.Venue, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return db.Venue{}, err
+	}
+	venue := db.Venue{
+		Name:    strings.TrimSpace(r.PostFormValue("Name")),
+		Address: strings.TrimSpace(r.PostFormValue("Address")),
+		Notes:   strings.TrimSpace(r.PostFormValue("Notes")),
+	}
+	if venue.Name == "" {
+		return venue, errors.New("the venue needs a name")
+	}
+	return venue, nil
+}
+
+func (v *View) SaveVenue(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Saving (new) Venue")
+	venue, err := venueFromForm(r)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	id, err := v.db.AddVenue(venue)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/venue/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) UpdateVenue(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Updating venue ", id)
+	venue, err := venueFromForm(r)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	venue.Id = int64(id)
+	err = v.db.UpdateVenue(venue)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/venue/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
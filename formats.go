@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"noodlizer/db"
+)
+
+// Format (setlist template) handlers. A format is built up a set and a slot
+// at a time, then filled to make a setlist.
+
+func (v *View) ShowFormats(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Show Formats.")
+	formats, err := v.db.GetAllFormats()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	data := struct {
+		Formats []db.Format
+		Gap     int
+	}{formats, db.DefaultGap}
+	err = v.index.ExecuteTemplate(w, "formats.tmpl", data)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+// formatPage is a format with what's needed to add slots, and what
+// happened the last time it was filled
+type formatPage struct {
+	db.Format
+	Tracks   []db.Track
+	Voxes    []db.Vox
+	Genres   []db.Genre
+	Setlist  int64 // made by Fill
+	Unfilled []string
+}
+
+func (v *View) renderFormat(w http.ResponseWriter, id int64, page formatPage) {
+	var err error
+	if page.Format, err = v.db.GetFormat(id); err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	if page.Tracks, err = v.db.GetAllTracks(); err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	if page.Voxes, err = v.db.GetAllVoxes(); err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	if page.Genres, err = v.db.GetAllGenres(); err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.index.ExecuteTemplate(w, "format.tmpl", page)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+func (v *View) ShowFormat(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Show Format ", id)
+	v.renderFormat(w, int64(id), formatPage{})
+}
+
+func (v *View) SaveFormat(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Saving (new) Format")
+	err := r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	f := db.Format{Name: strings.TrimSpace(r.PostFormValue("Name"))}
+	if f.Name == "" {
+		io.WriteString(w, "the format needs a name")
+		return
+	}
+	f.Gap, err = strconv.Atoi(r.PostFormValue("Gap"))
+	if err != nil || f.Gap < 0 {
+		io.WriteString(w, "gap between songs should be a number of seconds")
+		return
+	}
+	id, err := v.db.AddFormat(f)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/format/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) AddFormatSet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fs := db.FormatSet{FormatId: int64(id), Name: strings.TrimSpace(r.PostFormValue("Name"))}
+	if fs.Name == "" {
+		io.WriteString(w, "the set needs a name")
+		return
+	}
+	// minutes, blank for a set that's only its slots (or a break)
+	if t := strings.TrimSpace(r.PostFormValue("Target")); t != "" {
+		mins, err := strconv.Atoi(t)
+		if err != nil || mins < 0 {
+			io.WriteString(w, "set length should be a number of minutes")
+			return
+		}
+		fs.Target = mins * 60
+	}
+	_, err = v.db.AddFormatSet(fs)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/format/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) AddSlot(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	sid, err := strconv.Atoi(r.PathValue("sid"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	s := db.Slot{
+		SetId: int64(sid),
+		Name:  strings.TrimSpace(r.PostFormValue("Name")),
+		AtEnd: r.PostFormValue("AtEnd") == "on",
+	}
+	if s.Name == "" {
+		io.WriteString(w, "the slot needs a name")
+		return
+	}
+	s.Track.Id, _ = strconv.ParseInt(r.PostFormValue("Track"), 10, 64)
+	s.Vox.Id, _ = strconv.ParseInt(r.PostFormValue("Vox"), 10, 64)
+	s.Genre.Id, _ = strconv.ParseInt(r.PostFormValue("Genre"), 10, 64)
+	for _, tempo := range []struct {
+		field string
+		to    *int
+	}{{"MinTempo", &s.MinTempo}, {"MaxTempo", &s.MaxTempo}} {
+		t := strings.TrimSpace(r.PostFormValue(tempo.field))
+		if t == "" {
+			continue
+		}
+		*tempo.to, err = strconv.Atoi(t)
+		if err != nil || *tempo.to < 0 {
+			io.WriteString(w, fmt.Sprintf("tempo %q isn't a BPM", t))
+			return
+		}
+	}
+	_, err = v.db.AddSlot(s)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/format/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) DelFormatSet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	sid, err := strconv.Atoi(r.PathValue("sid"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.db.DelFormatSet(int64(sid))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/format/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) DelSlot(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	sid, err := strconv.Atoi(r.PathValue("sid"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.db.DelSlot(int64(sid))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/format/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// FillFormat makes a setlist from the format. If every slot was filled it's
+// off to the new setlist, otherwise back to the format to say which weren't.
+func (v *View) FillFormat(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Filling format ", id)
+	err = r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	name := strings.TrimSpace(r.PostFormValue("Name"))
+	if name == "" {
+		io.WriteString(w, "the setlist needs a name")
+		return
+	}
+	f, err := v.db.GetFormat(int64(id))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	tracks, err := v.db.GetAllTracks()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	sl, unfilled := f.Fill(name, tracks, rand.New(rand.NewSource(time.Now().UnixNano())))
+	slid, err := v.db.AddSetlistWithSets(sl)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	if len(unfilled) > 0 {
+		v.renderFormat(w, int64(id), formatPage{Setlist: slid, Unfilled: unfilled})
+		return
+	}
+	url := fmt.Sprintf("/setlist/%d/edit", slid)
+	http.Redirect(w, r, url, http.StatusFound)
+}
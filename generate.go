@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"noodlizer/db"
+)
+
+// Setlist generator: pick a pool of songs, say how many sets and how long,
+// and get a draft setlist to edit like any other.
+
+// smart lists narrow the pool by play history
+var smartLists = []struct{ Key, Name string }{
+	{"all", "Every song"},
+	{"due", "Due for rotation"},
+	{"played", "Played before"},
+	{"new", "Never played"},
+}
+
+type genForm struct {
+	Voxes      []db.Vox
+	Eras       []db.Era
+	Genres     []db.Genre
+	Kits       []db.Kit
+	SmartLists []struct{ Key, Name string }
+	Name       string
+	Sets       int
+	Target     int // minutes
+	Gap        int
+	Gigs       int
+}
+
+func (v *View) GenerateSetlist(w http.ResponseWriter, r *http.Request) {
+	f := genForm{
+		SmartLists: smartLists,
+		Name:       "draft " + time.Now().Format(db.DateFormat),
+		Sets:       3,
+		Target:     45,
+		Gap:        db.DefaultGap,
+		Gigs:       rotationGigs,
+	}
+	var err error
+	if f.Voxes, err = v.db.GetAllVoxes(); err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	if f.Eras, err = v.db.GetAllEras(); err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	if f.Genres, err = v.db.GetAllGenres(); err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	if f.Kits, err = v.db.GetAllKits(); err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.index.ExecuteTemplate(w, "generate_setlist.tmpl", f)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+// formIds is the ids checked for one of the pool filters, empty if none are
+func formIds(r *http.Request, name string) []int64 {
+	ids := []int64{}
+	for _, s := range r.PostForm[name] {
+		if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// allows is true if nothing was checked or id was
+func allows(ids []int64, id int64) bool {
+	return len(ids) == 0 || slices.Contains(ids, id)
+}
+
+// genPool is the songs that pass the form's filters and smart list
+func (v *View) genPool(r *http.Request) ([]db.Track, error) {
+	tracks, err := v.db.GetAllTracks()
+	if err != nil {
+		return nil, err
+	}
+	stats, err := v.db.GetPlayStats()
+	if err != nil {
+		return nil, err
+	}
+	voxes, eras := formIds(r, "Vox"), formIds(r, "Era")
+	genres, kits := formIds(r, "Genre"), formIds(r, "Kit")
+	smart := r.PostFormValue("Smart")
+	gigs := rotationParam(r)
+	pool := []db.Track{}
+	for _, t := range tracks {
+		if !allows(voxes, t.Vox.Id) || !allows(eras, t.Era.Id) || !allows(genres, t.Genre.Id) || !allows(kits, t.Kit.Id) {
+			continue
+		}
+		s := stats[t.Id]
+		switch {
+		case smart == "due" && !s.Due(gigs):
+			continue
+		case smart == "played" && s.Played == 0:
+			continue
+		case smart == "new" && s.Played != 0:
+			continue
+		}
+		pool = append(pool, t)
+	}
+	return pool, nil
+}
+
+func (v *View) SaveGeneratedSetlist(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Generating setlist")
+	err := r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	opt := db.GenOptions{Name: strings.TrimSpace(r.PostFormValue("Name"))}
+	if opt.Name == "" {
+		io.WriteString(w, "the setlist needs a name")
+		return
+	}
+	opt.Sets, err = strconv.Atoi(r.PostFormValue("Sets"))
+	if err != nil || opt.Sets < 1 {
+		io.WriteString(w, "number of sets should be 1 or more")
+		return
+	}
+	target, err := strconv.Atoi(r.PostFormValue("Target"))
+	if err != nil || target < 0 {
+		io.WriteString(w, "set length should be a number of minutes, 0 to use every song")
+		return
+	}
+	opt.Target = target * 60
+	opt.Gap, err = strconv.Atoi(r.PostFormValue("Gap"))
+	if err != nil || opt.Gap < 0 {
+		io.WriteString(w, "gap between songs should be a number of seconds")
+		return
+	}
+	pool, err := v.genPool(r)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	if len(pool) == 0 {
+		io.WriteString(w, "no songs match, loosen the filters")
+		return
+	}
+	opt.Segues, err = v.db.GetSegues()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	sl := db.Generate(pool, opt, rand.New(rand.NewSource(time.Now().UnixNano())))
+	id, err := v.db.AddSetlistWithSets(sl)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/setlist/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"noodlizer/db"
+)
+
+// Gig handlers. Every screen shows /gig/{id}, which renders wherever the gig
+// currently is. Moving around (next/prev) changes the stored gig and pushes
+// the new position over the websocket so every other screen on the gig
+// reloads. Once a device takes the lead only it can move the gig.
+
+// everything the gig templates (gig, set_end, setlist_end) need
+type gigPage struct {
+	Id       int64
+	Name     string
+	SetName  string
+	Status   string
+	Title    string
+	Lyrics   template.HTML
+	Sections []db.Section
+	Tempo    int
+	KeyTone  string
+	Pos      string // matched against the websocket broadcasts
+	Led      bool   // some device has the lead
+	Leader   bool   // and it is this one
+	Audible  bool   // showing a song that isn't in the set
+	Next     string // the next two songs in the set, if any
+	After    string
+	Sets     []db.Set // the whole gig for the overview
+	CurSet   int
+	CurTrack int
+	OnTrack  bool // CurSet/CurTrack is the song showing
+	Length   string
+	Notes    string    // for items that aren't songs
+	Segue    *db.Track // what it runs straight into, if anything
+}
+
+const deviceCookie = "noodlizer_device"
+
+// deviceId identifies the browser across page loads (the websocket id is per page)
+func (v *View) deviceId(w http.ResponseWriter, r *http.Request) string {
+	c, err := r.Cookie(deviceCookie)
+	if err == nil && c.Value != "" {
+		return c.Value
+	}
+	dev := rand.Text()
+	http.SetCookie(w, &http.Cookie{Name: deviceCookie, Value: dev, Path: "/", MaxAge: 60 * 60 * 24 * 365})
+	return dev
+}
+
+// mayMove is true if there is no leader or this device is it
+func (v *View) mayMove(w http.ResponseWriter, r *http.Request, g *db.Gig) bool {
+	return g.Leader == "" || g.Leader == v.deviceId(w, r)
+}
+
+// lockGig holds the gig's lock until the returned func is called, so two
+// quick presses can't both load the same gig and one of them be lost
+func (v *View) lockGig(id int64) func() {
+	v.gig_mtx.Lock()
+	l, ok := v.gig_locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		v.gig_locks[id] = l
+	}
+	v.gig_mtx.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+func gigPos(g *db.Gig) string {
+	return fmt.Sprintf("%d.%d.%s.%d.%d", g.CurSet, g.CurTrack, g.State, g.Audible, g.Rev)
+}
+
+// resumeGigs picks the stored gigs back up at startup so screens that
+// reconnect are sent to wherever their gig was left
+func (v *View) resumeGigs() {
+	gigs, err := v.db.GetAllGigs()
+	if err != nil {
+		fmt.Println("resumeGigs: ", err.Error())
+		return
+	}
+	v.ws_mtx.Lock()
+	defer v.ws_mtx.Unlock()
+	for _, g := range gigs {
+		fmt.Println("resuming gig", g.Id, g.Name)
+		v.gigpos[g.Id] = gigMsg(&g)
+	}
+}
+
+func gigMsg(g *db.Gig) []byte {
+	return []byte(fmt.Sprintf(`{"type":"gig","id":"%d","pos":"%s"}`, g.Id, gigPos(g)))
+}
+
+// publishGig tells every screen where the gig is now, and remembers it for late subscribers
+func (v *View) publishGig(g *db.Gig) {
+	msg := gigMsg(g)
+	v.ws_mtx.Lock()
+	v.gigpos[g.Id] = msg
+	v.ws_mtx.Unlock()
+	v.sendMsgAll(msg)
+}
+
+func (v *View) StartGig(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Start a gig")
+	setlists, err := v.db.GetAllSetlists()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	// gigs stay until they're ended, so they can be picked back up after a restart
+	gigs, err := v.db.GetAllGigs()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	data := struct {
+		Gigs     []db.Gig
+		Setlists []db.Setlist
+	}{gigs, setlists}
+	err = v.index.ExecuteTemplate(w, "launch_gig.tmpl", data)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+func (v *View) DoGig(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("DoGig.1: %s", err.Error()))
+		return
+	}
+	fmt.Println("Doin' gig", id)
+	sl, err := v.db.GetSetlist(int64(id))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("DoGig.2: %s", err.Error()))
+		return
+	}
+	gig, err := v.db.NewGig(sl)
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("DoGig.3: %s", err.Error()))
+		return
+	}
+	// whoever launches it leads it
+	gig.Leader = v.deviceId(w, r)
+	err = v.db.UpdateGig(gig)
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("DoGig.4: %s", err.Error()))
+		return
+	}
+	url := fmt.Sprintf("/gig/%d", gig.Id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// ShowGig renders the current position without moving
+func (v *View) ShowGig(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("ShowGig.1: %s", err.Error()))
+		return
+	}
+	g, err := v.db.GetGig(int64(id))
+	if errors.Is(err, sql.ErrNoRows) {
+		// ended while this screen was away
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("ShowGig.2: %s", err.Error()))
+		return
+	}
+	v.renderGig(w, r, g)
+}
+
+func (v *View) renderGig(w http.ResponseWriter, r *http.Request, g *db.Gig) {
+	data := gigPage{
+		Id:       g.Id,
+		Name:     g.ProperName(),
+		Pos:      gigPos(g),
+		Led:      g.Leader != "",
+		Leader:   g.Leader != "" && g.Leader == v.deviceId(w, r),
+		Sets:     g.Sets,
+		CurSet:   g.CurSet,
+		CurTrack: g.CurTrack,
+		OnTrack:  g.IsOnTrack(),
+	}
+	tmpl := "gig.tmpl"
+	switch {
+	case g.Audible != 0:
+		track, err := v.db.GetTrack(g.Audible)
+		if err != nil {
+			io.WriteString(w, fmt.Sprintf("renderGig.3: %s", err.Error()))
+			return
+		}
+		data.Audible = true
+		data.SetName = "Audible"
+		data.Title = track.ProperTitle()
+		data.Tempo = track.Tempo
+		data.KeyTone = track.KeyTone
+		data.Lyrics = track.Lyrics.GigText(0)
+		data.Sections = track.Lyrics.GigSections()
+	case g.State == db.GigStart || g.State == db.GigEnd:
+		data.Status = g.State
+		tmpl = "setlist_end.tmpl"
+	case g.State == db.SetEnd:
+		data.Status = "End"
+		data.SetName = g.Sets[g.CurSet].ProperName()
+		tmpl = "set_end.tmpl"
+	default:
+		data.SetName = g.Sets[g.CurSet].ProperName()
+		t, _ := g.Current()
+		up := g.Upcoming(2)
+		if len(up) > 0 {
+			data.Next = up[0].ProperTitle()
+		}
+		if len(up) > 1 {
+			data.After = up[1].ProperTitle()
+		}
+		if into, ok := g.Sets[g.CurSet].SegueInto(g.CurTrack); ok {
+			data.Segue = &into
+		}
+		if t.IsItem() {
+			data.Title = t.ProperTitle()
+			data.Length = t.Length()
+			data.Notes = t.Notes
+			tmpl = "gig_item.tmpl"
+			break
+		}
+		track, err := v.db.GetTrack(t.Id)
+		if err != nil {
+			io.WriteString(w, fmt.Sprintf("renderGig.1: %s", err.Error()))
+			return
+		}
+		data.Title = t.ProperTitle()
+		data.Tempo = t.Tempo
+		data.KeyTone = t.KeyTone
+		data.Lyrics = track.Lyrics.GigText(0)
+		data.Sections = track.Lyrics.GigSections()
+	}
+	err := v.index.ExecuteTemplate(w, tmpl, data)
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("renderGig.2: %s", err.Error()))
+	}
+}
+
+// moveGig loads the gig, applies the move if this device may, then saves, tells everyone
+// and sends this device to the new position
+func (v *View) moveGig(w http.ResponseWriter, r *http.Request, move func(g *db.Gig)) {
+	v.tryMoveGig(w, r, func(g *db.Gig) error {
+		move(g)
+		return nil
+	})
+}
+
+// tryMoveGig is moveGig for moves that can be refused, then nothing is saved or sent
+func (v *View) tryMoveGig(w http.ResponseWriter, r *http.Request, move func(g *db.Gig) error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("moveGig.1: %s", err.Error()))
+		return
+	}
+	url := fmt.Sprintf("/gig/%d", id)
+	defer v.lockGig(int64(id))()
+	g, err := v.db.GetGig(int64(id))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("moveGig.2: %s", err.Error()))
+		return
+	}
+	if !v.mayMove(w, r, g) {
+		fmt.Println("not the leader of gig", id)
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+	err = move(g)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	g.Rev++
+	err = v.db.UpdateGig(g)
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("moveGig.3: %s", err.Error()))
+		return
+	}
+	fmt.Printf("gig %d %s set %d track %d\n", g.Id, g.State, g.CurSet, g.CurTrack)
+	v.publishGig(g)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// The moves themselves live on db.Gig, these just apply them
+
+func (v *View) ShowGigNext(w http.ResponseWriter, r *http.Request) {
+	v.moveGig(w, r, func(g *db.Gig) { g.Next() })
+}
+
+func (v *View) ShowGigPrev(w http.ResponseWriter, r *http.Request) {
+	v.moveGig(w, r, func(g *db.Gig) { g.Prev() })
+}
+
+// Live edits to the running gig. They only change the gig's copy of the sets,
+// the setlist it came from is left alone.
+
+func (v *View) SkipGigTrack(w http.ResponseWriter, r *http.Request) {
+	v.moveGig(w, r, func(g *db.Gig) { g.Skip() })
+}
+
+func (v *View) DeferGigTrack(w http.ResponseWriter, r *http.Request) {
+	v.moveGig(w, r, func(g *db.Gig) { g.Defer() })
+}
+
+func (v *View) DropGigTrack(w http.ResponseWriter, r *http.Request) {
+	v.moveGig(w, r, func(g *db.Gig) { g.Drop() })
+}
+
+func (v *View) SwapGigNext(w http.ResponseWriter, r *http.Request) {
+	v.moveGig(w, r, func(g *db.Gig) { g.SwapNext() })
+}
+
+// FindAudible searches the whole catalog for a song to call that isn't in the set
+func (v *View) FindAudible(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("FindAudible.1: %s", err.Error()))
+		return
+	}
+	g, err := v.db.GetGig(int64(id))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("FindAudible.2: %s", err.Error()))
+		return
+	}
+	q := strings.ToLower(strings.TrimSpace(r.FormValue("q")))
+	all, err := v.db.GetAllTracks()
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("FindAudible.3: %s", err.Error()))
+		return
+	}
+	tracks := []db.Track{}
+	for _, t := range all {
+		if q == "" || strings.Contains(t.Title, q) {
+			tracks = append(tracks, t)
+		}
+	}
+	data := struct {
+		gigPage
+		Query  string
+		Tracks []db.Track
+	}{
+		gigPage: gigPage{
+			Id:     g.Id,
+			Name:   g.ProperName(),
+			Pos:    gigPos(g),
+			Led:    g.Leader != "",
+			Leader: g.Leader != "" && g.Leader == v.deviceId(w, r),
+		},
+		Query:  q,
+		Tracks: tracks,
+	}
+	err = v.index.ExecuteTemplate(w, "audible.tmpl", data)
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("FindAudible.4: %s", err.Error()))
+	}
+}
+
+// CallAudible puts a catalog track up on every screen, the set position stays put
+func (v *View) CallAudible(w http.ResponseWriter, r *http.Request) {
+	tid, err := strconv.Atoi(r.PathValue("tid"))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("CallAudible.1: %s", err.Error()))
+		return
+	}
+	// every screen would be stuck on an error if it isn't a song
+	if _, err = v.db.GetTrack(int64(tid)); err != nil {
+		io.WriteString(w, fmt.Sprintf("CallAudible.2: no song %d: %s", tid, err.Error()))
+		return
+	}
+	v.moveGig(w, r, func(g *db.Gig) { g.CallAudible(int64(tid)) })
+}
+
+// EndAudible goes back to the planned position
+func (v *View) EndAudible(w http.ResponseWriter, r *http.Request) {
+	v.moveGig(w, r, func(g *db.Gig) { g.EndAudible() })
+}
+
+// GotoGigTrack jumps straight to a song anywhere in the gig
+func (v *View) GotoGigTrack(w http.ResponseWriter, r *http.Request) {
+	set, err := strconv.Atoi(r.PathValue("set"))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("GotoGigTrack.1: %s", err.Error()))
+		return
+	}
+	track, err := strconv.Atoi(r.PathValue("track"))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("GotoGigTrack.2: %s", err.Error()))
+		return
+	}
+	v.tryMoveGig(w, r, func(g *db.Gig) error {
+		_, err := g.Goto(set, track)
+		return err
+	})
+}
+
+// LeadGig makes this device the only one that can move the gig, or gives up the lead.
+// The lead is stored with the gig so it survives a restart. It's a POST so taking
+// over from another device goes through the confirm on the button.
+func (v *View) LeadGig(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("LeadGig.1: %s", err.Error()))
+		return
+	}
+	defer v.lockGig(int64(id))()
+	g, err := v.db.GetGig(int64(id))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("LeadGig.2: %s", err.Error()))
+		return
+	}
+	dev := v.deviceId(w, r)
+	if g.Leader == dev {
+		g.Leader = ""
+	} else {
+		g.Leader = dev
+	}
+	// the other screens reload to show or hide their controls
+	g.Rev++
+	err = v.db.UpdateGig(g)
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("LeadGig.3: %s", err.Error()))
+		return
+	}
+	v.publishGig(g)
+	url := fmt.Sprintf("/gig/%d", id)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) EndGig(w http.ResponseWriter, r *http.Request) {
+	// log the last song, delete the current gig by id and redirect to the main page
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("EndGig.1: %s", err.Error()))
+		return
+	}
+	defer v.lockGig(int64(id))()
+	g, err := v.db.GetGig(int64(id))
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("EndGig.2: %s", err.Error()))
+		return
+	}
+	// it ends for everyone, so only whoever may move it can end it
+	if !v.mayMove(w, r, g) {
+		fmt.Println("not the leader of gig", id)
+		http.Redirect(w, r, fmt.Sprintf("/gig/%d", id), http.StatusFound)
+		return
+	}
+	fmt.Println("ending gig", id)
+	err = v.db.EndGig(g)
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("EndGig.3: %s", err.Error()))
+		return
+	}
+	v.ws_mtx.Lock()
+	delete(v.gigpos, int64(id))
+	v.ws_mtx.Unlock()
+	v.gig_mtx.Lock()
+	delete(v.gig_locks, int64(id))
+	v.gig_mtx.Unlock()
+	// send the other screens on the gig home
+	v.sendMsgAll([]byte(fmt.Sprintf(`{"type":"ended","id":"%d"}`, id)))
+	url := "/"
+	http.Redirect(w, r, url, http.StatusFound)
+}
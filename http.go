@@ -5,6 +5,8 @@ import (
 	"html/template"
 	"io"
 	"net/http"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,11 +15,14 @@ import (
 )
 
 type View struct {
-	db      *db.DB
-	index   *template.Template
-	ws_mtx  sync.Mutex
-	subs    map[*subscriber]struct{}
-	waiters map[string]struct{}
+	db        *db.DB
+	index     *template.Template
+	ws_mtx    sync.Mutex
+	subs      map[*subscriber]struct{}
+	waiters   map[string]struct{}
+	gigpos    map[int64][]byte // gig id -> last position broadcast
+	gig_mtx   sync.Mutex
+	gig_locks map[int64]*sync.Mutex // gig id -> held while a move loads, changes and saves it
 }
 
 type trackInfo struct {
@@ -30,9 +35,11 @@ type trackInfo struct {
 
 func NewView(db *db.DB) *View {
 	v := &View{
-		db:      db,
-		subs:    make(map[*subscriber]struct{}),
-		waiters: make(map[string]struct{}),
+		db:        db,
+		subs:      make(map[*subscriber]struct{}),
+		waiters:   make(map[string]struct{}),
+		gigpos:    make(map[int64][]byte),
+		gig_locks: make(map[int64]*sync.Mutex),
 	}
 	// static path
 	fs := http.FileServer(http.Dir("./static/"))
@@ -46,19 +53,43 @@ func NewView(db *db.DB) *View {
 	http.HandleFunc("POST /ready", v.UpdateGigPause)
 	http.HandleFunc("/setlists", v.ShowSetlists)
 	http.HandleFunc("/tracks", v.ShowAllTracks)
+	http.HandleFunc("/history", v.ShowHistory)
 	http.HandleFunc("/voxes", v.ShowVoxes)
 	http.HandleFunc("/eras", v.ShowEras)
 	http.HandleFunc("/genres", v.ShowGenres)
 	http.HandleFunc("/kits", v.ShowKits)
+	http.HandleFunc("/formats", v.ShowFormats)
+	http.HandleFunc("/format/{id}", v.ShowFormat)
+	http.HandleFunc("POST /format/save", v.SaveFormat)
+	http.HandleFunc("POST /format/{id}/add_set", v.AddFormatSet)
+	http.HandleFunc("POST /format/{id}/set/{sid}/add_slot", v.AddSlot)
+	http.HandleFunc("/format/{id}/del_set/{sid}", v.DelFormatSet)
+	http.HandleFunc("/format/{id}/del_slot/{sid}", v.DelSlot)
+	http.HandleFunc("POST /format/{id}/fill", v.FillFormat)
+	http.HandleFunc("/rules", v.ShowRules)
+	http.HandleFunc("POST /rule/save", v.SaveRule)
+	http.HandleFunc("/rule/{id}/delete", v.DelRule)
 	http.HandleFunc("/set/{id}", v.ShowSet)
 	http.HandleFunc("/set/{id}/edit", v.EditSet)
 	http.HandleFunc("/set/{sid}/add_track/{tid}", v.AddTrackToSet)
+	http.HandleFunc("POST /set/{id}/copy", v.CopySet)
+	http.HandleFunc("/set/{id}/delete", v.DelSet)
+	http.HandleFunc("/set/{id}/move/{setnum}", v.MoveSet)
 	http.HandleFunc("/set/{sid}/del_track/{tid}", v.DelTrackFromSet)
+	http.HandleFunc("POST /set/{sid}/add_item", v.AddItemToSet)
+	http.HandleFunc("/set/{sid}/del_entry/{entry}", v.DelEntryFromSet)
+	http.HandleFunc("/set/{sid}/segue/{entry}", v.ToggleSegue)
+	http.HandleFunc("/set/{sid}/up/{entry}", v.MoveEntryUp)
+	http.HandleFunc("/set/{sid}/down/{entry}", v.MoveEntryDown)
 	http.HandleFunc("/setlist/{id}", v.ShowSetlist)
 	http.HandleFunc("/setlist/create", v.CreateSetlist)
+	http.HandleFunc("/setlist/generate", v.GenerateSetlist)
+	http.HandleFunc("/setlist/compare", v.CompareSetlists)
+	http.HandleFunc("POST /setlist/generate", v.SaveGeneratedSetlist)
 	http.HandleFunc("/setlist/{id}/edit", v.EditSetlist)
 	http.HandleFunc("POST /setlist/save", v.SaveSetlist)
 	http.HandleFunc("POST /setlist/{id}/update", v.UpdateSetlist)
+	http.HandleFunc("POST /setlist/{id}/clone", v.CloneSetlist)
 	http.HandleFunc("/setlist/{id}/create_set/{setnum}", v.CreateSet)
 	http.HandleFunc("POST /setlist/{id}/save_set", v.SaveSet)
 	http.HandleFunc("POST /setlist/{id}/update_set", v.UpdateSet)
@@ -66,13 +97,40 @@ func NewView(db *db.DB) *View {
 	http.HandleFunc("/track/{id}/edit", v.EditTrack)
 	http.HandleFunc("POST /track/{id}/update", v.UpdateTrack)
 	http.HandleFunc("POST /track/{id}/update_lyrics", v.UpdateLyrics)
+	// same handler, the extension picks the renderer
+	http.HandleFunc("POST /lyrics/preview", v.PreviewLyrics)
+	http.HandleFunc("/track/{id}/lyrics.txt", v.ShowLyrics)
+	http.HandleFunc("/track/{id}/lyrics.json", v.ShowLyrics)
+	http.HandleFunc("/track/{id}/lyrics.ansi", v.ShowLyrics)
 	http.HandleFunc("/vox/{id}", v.ShowVox)
 	http.HandleFunc("/era/{id}", v.ShowEra)
 	http.HandleFunc("/genre/{id}", v.ShowGenre)
 	http.HandleFunc("/kit/{id}", v.ShowKit)
+	http.HandleFunc("/events", v.ShowEvents)
+	http.HandleFunc("/events.ics", v.EventsCalendar)
+	http.HandleFunc("/event/{id}/event.ics", v.EventCalendar)
+	http.HandleFunc("/event/{id}", v.ShowEvent)
+	http.HandleFunc("/event/create", v.CreateEvent)
+	http.HandleFunc("/event/{id}/edit", v.EditEvent)
+	http.HandleFunc("POST /event/save", v.SaveEvent)
+	http.HandleFunc("POST /event/{id}/update", v.UpdateEvent)
+	http.HandleFunc("/venues", v.ShowVenues)
+	http.HandleFunc("/venue/{id}", v.ShowVenue)
+	http.HandleFunc("POST /venue/save", v.SaveVenue)
+	http.HandleFunc("POST /venue/{id}/update", v.UpdateVenue)
 	http.HandleFunc("/gig/", v.StartGig)
+	http.HandleFunc("/gig/{id}", v.ShowGig)
 	http.HandleFunc("/gig/next/{id}", v.ShowGigNext)
 	http.HandleFunc("/gig/prev/{id}", v.ShowGigPrev)
+	http.HandleFunc("POST /gig/lead/{id}", v.LeadGig)
+	http.HandleFunc("/gig/skip/{id}", v.SkipGigTrack)
+	http.HandleFunc("/gig/defer/{id}", v.DeferGigTrack)
+	http.HandleFunc("/gig/drop/{id}", v.DropGigTrack)
+	http.HandleFunc("/gig/swap/{id}", v.SwapGigNext)
+	http.HandleFunc("/gig/{id}/goto/{set}/{track}", v.GotoGigTrack)
+	http.HandleFunc("/gig/audible/{id}", v.FindAudible)
+	http.HandleFunc("/gig/audible/{id}/{tid}", v.CallAudible)
+	http.HandleFunc("/gig/audible/{id}/done", v.EndAudible)
 	http.HandleFunc("/gig/end/{id}", v.EndGig)
 	http.HandleFunc("/gig/setlist/{id}", v.DoGig)
 
@@ -80,9 +138,13 @@ func NewView(db *db.DB) *View {
 	fmap := template.FuncMap{
 		"inc": func(i int) int {
 			return i + 1
+		},
+		"dec": func(i int) int {
+			return i - 1
 		}}
 	v.index = template.Must(template.New("main").Funcs(fmap).ParseGlob("./template/*.tmpl"))
 
+	v.resumeGigs()
 	go v.servicePause()
 	return v
 }
@@ -93,7 +155,12 @@ func NewView(db *db.DB) *View {
 func (v *View) Index(w http.ResponseWriter, r *http.Request) {
 	//io.WriteString(w, "index.")
 	//http.Redirect(w, r, "/tracks", http.StatusFound)
-	err := v.index.ExecuteTemplate(w, "index.tmpl", nil)
+	events, err := v.db.GetUpcomingEvents()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.index.ExecuteTemplate(w, "index.tmpl", struct{ Events []db.Event }{Events: events})
 	if err != nil {
 		io.WriteString(w, err.Error())
 	}
@@ -111,7 +178,21 @@ func (v *View) ShowSet(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, err.Error())
 		return
 	}
-	err = v.index.ExecuteTemplate(w, "set.tmpl", set)
+	setlist, err := v.db.GetSetlist(set.SetlistId)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	setlists, err := v.db.GetAllSetlists()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.index.ExecuteTemplate(w, "set.tmpl", struct {
+		db.Set
+		Time     db.SetTime
+		Setlists []db.Setlist // to copy the set into
+	}{set, setlist.Timing(set), setlists})
 	if err != nil {
 		io.WriteString(w, err.Error())
 	}
@@ -129,17 +210,55 @@ func (v *View) EditSet(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, err.Error())
 		return
 	}
+	setlist, err := v.db.GetSetlist(set.SetlistId)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
 	tracks, err := v.db.GetAllTracks()
 	if err != nil {
 		io.WriteString(w, err.Error())
 		return
 	}
+	stats, err := v.db.GetPlayStats()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	// songs not in the set that have sat out the last few gigs, longest rested first
+	gigs := rotationParam(r)
+	due := []trackStats{}
+	for _, t := range tracks {
+		in := slices.ContainsFunc(set.Tracks, func(st db.Track) bool { return st.Id == t.Id })
+		if !in && stats[t.Id].Due(gigs) {
+			due = append(due, trackStats{Track: t, Stats: stats[t.Id]})
+		}
+	}
+	slices.SortStableFunc(due, trackSorts["rested"])
+	rules, err := v.db.GetRules()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	warns := db.CheckSet(rules, set)
+	flagged := map[int]bool{}
+	for _, fw := range warns {
+		for pos := fw.Pos; pos <= fw.Last; pos++ {
+			flagged[pos] = true
+		}
+	}
 
 	err = v.index.ExecuteTemplate(w, "edit_set.tmpl", struct {
-		Set    db.Set
-		Tracks []db.Track
-		Action string
-	}{Set: set, Tracks: tracks, Action: "update"})
+		Set      db.Set
+		Tracks   []db.Track
+		Due      []trackStats
+		Gigs     int
+		Time     db.SetTime
+		Warnings []db.FlowWarning
+		Flagged  map[int]bool // song numbers with a warning
+		Action   string
+	}{Set: set, Tracks: tracks, Due: due, Gigs: gigs, Time: setlist.Timing(set),
+		Warnings: warns, Flagged: flagged, Action: "update"})
 	if err != nil {
 		io.WriteString(w, err.Error())
 	}
@@ -159,9 +278,14 @@ func (v *View) CreateSet(w http.ResponseWriter, r *http.Request) {
 	s := db.Set{SetlistId: int64(id), SetNum: setnum}
 	t := []db.Track{}
 	err = v.index.ExecuteTemplate(w, "edit_set.tmpl", struct {
-		Set    db.Set
-		Tracks []db.Track
-		Action string
+		Set      db.Set
+		Tracks   []db.Track
+		Due      []trackStats
+		Gigs     int
+		Time     db.SetTime
+		Warnings []db.FlowWarning
+		Flagged  map[int]bool
+		Action   string
 	}{Set: s, Tracks: t, Action: "save"})
 	if err != nil {
 		io.WriteString(w, err.Error())
@@ -181,8 +305,17 @@ func (v *View) SaveSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := r.PostFormValue("Name")
-	setnum, _ := strconv.Atoi(r.PostFormValue("Setnum"))
-	s := db.Set{SetlistId: int64(id), SetNum: setnum, Name: name}
+	setnum, err := formSetnum(r)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	target, err := formTarget(r)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	s := db.Set{SetlistId: int64(id), SetNum: setnum, Name: name, Target: target}
 	_, err = v.db.AddSet(s)
 	if err != nil {
 		io.WriteString(w, err.Error())
@@ -207,9 +340,18 @@ func (v *View) UpdateSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := r.PostFormValue("Name")
-	setnum, _ := strconv.Atoi(r.PostFormValue("Setnum"))
+	setnum, err := formSetnum(r)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	target, err := formTarget(r)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
 	setid, _ := strconv.Atoi(r.PostFormValue("SetId"))
-	s := db.Set{Id: int64(setid), SetlistId: int64(id), SetNum: setnum, Name: name}
+	s := db.Set{Id: int64(setid), SetlistId: int64(id), SetNum: setnum, Name: name, Target: target}
 	err = v.db.UpdateSet(s)
 	if err != nil {
 		io.WriteString(w, err.Error())
@@ -220,6 +362,66 @@ func (v *View) UpdateSet(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// formSetnum is the set number on the set form, counted from 1 there
+func formSetnum(r *http.Request) (int, error) {
+	setnum, err := strconv.Atoi(r.PostFormValue("Setnum"))
+	if err != nil || setnum < 1 {
+		return 0, fmt.Errorf("set number %q should be 1 or more", r.PostFormValue("Setnum"))
+	}
+	return setnum - 1, nil
+}
+
+// formTarget is the set's target length in seconds, entered in minutes and
+// blank to go by the setlist's
+func formTarget(r *http.Request) (int, error) {
+	t := strings.TrimSpace(r.PostFormValue("Target"))
+	if t == "" {
+		return 0, nil
+	}
+	mins, err := strconv.Atoi(t)
+	if err != nil || mins < 0 {
+		return 0, fmt.Errorf("set target %q should be a number of minutes", t)
+	}
+	return mins * 60, nil
+}
+
+func (v *View) DelSet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Deleting set ", id)
+	setlistId, err := v.db.DelSet(int64(id))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/setlist/%d/edit", setlistId)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// MoveSet moves a set to another place in its setlist, the rest shuffle along
+func (v *View) MoveSet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	setnum, err := strconv.Atoi(r.PathValue("setnum"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	setlistId, err := v.db.MoveSet(int64(id), setnum)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/setlist/%d/edit", setlistId)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
 func (v *View) AddTrackToSet(w http.ResponseWriter, r *http.Request) {
 	sid, err := strconv.Atoi(r.PathValue("sid"))
 	if err != nil {
@@ -260,6 +462,110 @@ func (v *View) DelTrackFromSet(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
+// AddItemToSet adds something that isn't a song, like band intros or a raffle
+func (v *View) AddItemToSet(w http.ResponseWriter, r *http.Request) {
+	sid, err := strconv.Atoi(r.PathValue("sid"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	item := db.Track{
+		Title: strings.TrimSpace(r.PostFormValue("Title")),
+		Notes: strings.TrimSpace(r.PostFormValue("Notes")),
+	}
+	if item.Title == "" {
+		io.WriteString(w, "the item needs a title")
+		return
+	}
+	item.Duration, err = db.ParseLength(r.PostFormValue("Length"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.db.AddItemToSet(int64(sid), item)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/set/%d/edit", sid)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// DelEntryFromSet removes one song or item, by its row in the set
+func (v *View) DelEntryFromSet(w http.ResponseWriter, r *http.Request) {
+	sid, err := strconv.Atoi(r.PathValue("sid"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	entry, err := strconv.Atoi(r.PathValue("entry"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.db.RemEntryFromSet(int64(sid), int64(entry))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/set/%d/edit", sid)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) ToggleSegue(w http.ResponseWriter, r *http.Request) {
+	sid, err := strconv.Atoi(r.PathValue("sid"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	entry, err := strconv.Atoi(r.PathValue("entry"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.db.ToggleSegue(int64(sid), int64(entry))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/set/%d/edit", sid)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (v *View) MoveEntryUp(w http.ResponseWriter, r *http.Request) {
+	v.moveEntry(w, r, true)
+}
+
+func (v *View) MoveEntryDown(w http.ResponseWriter, r *http.Request) {
+	v.moveEntry(w, r, false)
+}
+
+// moveEntry moves a song or item one place, a medley moves as a whole
+func (v *View) moveEntry(w http.ResponseWriter, r *http.Request, up bool) {
+	sid, err := strconv.Atoi(r.PathValue("sid"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	entry, err := strconv.Atoi(r.PathValue("entry"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.db.MoveEntry(int64(sid), int64(entry), up)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/set/%d/edit", sid)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
 func (v *View) ShowSetlists(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Show Setlists.")
 	setlists, err := v.db.GetAllSetlists()
@@ -285,7 +591,22 @@ func (v *View) ShowSetlist(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, err.Error())
 		return
 	}
-	err = v.index.ExecuteTemplate(w, "setlist.tmpl", setlist)
+	events, err := v.db.GetSetlistEvents(setlist.Id)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	warns, err := v.setWarnings(setlist)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	data := struct {
+		db.Setlist
+		Events   []db.Event
+		Warnings map[int64][]db.FlowWarning
+	}{setlist, events, warns}
+	err = v.index.ExecuteTemplate(w, "setlist.tmpl", data)
 	if err != nil {
 		io.WriteString(w, err.Error())
 	}
@@ -349,7 +670,21 @@ func (v *View) UpdateSetlist(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := r.PostFormValue("Name")
-	s := db.Setlist{Id: int64(id), Name: name}
+	gap, err := strconv.Atoi(r.PostFormValue("Gap"))
+	if err != nil || gap < 0 {
+		io.WriteString(w, "gap between songs should be a number of seconds")
+		return
+	}
+	// the target is entered in minutes, blank for none
+	target := 0
+	if t := strings.TrimSpace(r.PostFormValue("SetTarget")); t != "" {
+		target, err = strconv.Atoi(t)
+		if err != nil || target < 0 {
+			io.WriteString(w, "set target should be a number of minutes")
+			return
+		}
+	}
+	s := db.Setlist{Id: int64(id), Name: name, Gap: gap, SetTarget: target * 60}
 	err = v.db.UpdateSetlist(s)
 	if err != nil {
 		io.WriteString(w, err.Error())
@@ -359,6 +694,122 @@ func (v *View) UpdateSetlist(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
+// CompareSetlists shows setlists ?a= and ?b= side by side, just the pickers
+// until both are chosen
+func (v *View) CompareSetlists(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Compare Setlists")
+	setlists, err := v.db.GetAllSetlists()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	data := struct {
+		Setlists []db.Setlist
+		A, B     int64
+		Diff     *db.SetlistDiff
+	}{Setlists: setlists}
+	data.A, _ = strconv.ParseInt(r.FormValue("a"), 10, 64)
+	data.B, _ = strconv.ParseInt(r.FormValue("b"), 10, 64)
+	if data.A != 0 && data.B != 0 {
+		a, err := v.db.GetSetlist(data.A)
+		if err != nil {
+			io.WriteString(w, err.Error())
+			return
+		}
+		b, err := v.db.GetSetlist(data.B)
+		if err != nil {
+			io.WriteString(w, err.Error())
+			return
+		}
+		diff := db.CompareSetlists(a, b)
+		data.Diff = &diff
+	}
+	err = v.index.ExecuteTemplate(w, "compare.tmpl", data)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+// CloneSetlist starts a new setlist as a copy of this one, sets and all
+func (v *View) CloneSetlist(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Cloning setlist ", id)
+	err = r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	name := strings.TrimSpace(r.PostFormValue("Name"))
+	if name == "" {
+		io.WriteString(w, "the copy needs a name")
+		return
+	}
+	newId, err := v.db.CloneSetlist(int64(id), name)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/setlist/%d/edit", newId)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// CopySet adds a copy of the set to the end of another (or the same) setlist
+func (v *View) CopySet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	setlistId, err := strconv.ParseInt(r.PostFormValue("SetlistId"), 10, 64)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Printf("Copying set %d to setlist %d\n", id, setlistId)
+	_, err = v.db.CopySet(int64(id), setlistId)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	url := fmt.Sprintf("/setlist/%d/edit", setlistId)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// a track with how often it gets played
+type trackStats struct {
+	db.Track
+	Stats db.PlayStats
+}
+
+// how many gigs a song sits out before it's due for rotation, ?gigs=N overrides
+const rotationGigs = 3
+
+func rotationParam(r *http.Request) int {
+	n, err := strconv.Atoi(r.FormValue("gigs"))
+	if err != nil || n < 1 {
+		return rotationGigs
+	}
+	return n
+}
+
+// sorts for the track list, ?sort=<key>
+var trackSorts = map[string]func(a, b trackStats) int{
+	"title":  func(a, b trackStats) int { return strings.Compare(a.Title, b.Title) },
+	"played": func(a, b trackStats) int { return b.Stats.Played - a.Stats.Played },
+	"last":   func(a, b trackStats) int { return int(b.Stats.LastPlayed - a.Stats.LastPlayed) },
+	"avg":    func(a, b trackStats) int { return int(b.Stats.AvgSecs - a.Stats.AvgSecs) },
+	"rested": func(a, b trackStats) int { return b.Stats.GigsSince - a.Stats.GigsSince },
+}
+
 func (v *View) ShowAllTracks(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Show Tracks.")
 	tracks, err := v.db.GetAllTracks()
@@ -367,7 +818,24 @@ func (v *View) ShowAllTracks(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, err.Error())
 		return
 	}
-	err = v.index.ExecuteTemplate(w, "tracks.tmpl", struct{ Tracks []db.Track }{Tracks: tracks})
+	stats, err := v.db.GetPlayStats()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	rows := []trackStats{}
+	for _, t := range tracks {
+		rows = append(rows, trackStats{Track: t, Stats: stats[t.Id]})
+	}
+	sort := r.FormValue("sort")
+	if cmp, ok := trackSorts[sort]; ok {
+		slices.SortStableFunc(rows, cmp)
+	}
+	err = v.index.ExecuteTemplate(w, "tracks.tmpl", struct {
+		Tracks []trackStats
+		Sort   string
+		Gigs   int
+	}{Tracks: rows, Sort: sort, Gigs: rotationParam(r)})
 	if err != nil {
 		io.WriteString(w, err.Error())
 	}
@@ -385,7 +853,30 @@ func (v *View) ShowTrack(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, err.Error())
 		return
 	}
-	err = v.index.ExecuteTemplate(w, "track.tmpl", t)
+	played, err := v.db.GetTrackPerformances(t.Id)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	data := struct {
+		db.Track
+		Played []db.Performance
+	}{t, played}
+	err = v.index.ExecuteTemplate(w, "track.tmpl", data)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+// ShowHistory lists every song played at a gig, latest first
+func (v *View) ShowHistory(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Show History.")
+	played, err := v.db.GetPerformances()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	err = v.index.ExecuteTemplate(w, "history.tmpl", struct{ Played []db.Performance }{Played: played})
 	if err != nil {
 		io.WriteString(w, err.Error())
 	}
@@ -453,17 +944,23 @@ func (v *View) UpdateTrack(w http.ResponseWriter, r *http.Request) {
 	genre_id, _ := strconv.Atoi(r.PostForm["Genre"][0])
 	kit_id, _ := strconv.Atoi(r.PostForm["Kit"][0])
 	key_tone := r.PostForm["KeyTone"][0]
+	duration, err := db.ParseLength(r.PostFormValue("Length"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
 
 	t := db.Track{
-		Id:      int64(id),
-		Title:   title,
-		Tempo:   tempo,
-		Click:   click,
-		KeyTone: key_tone,
-		Vox:     db.Vox{Id: int64(vox_id)},
-		Era:     db.Era{Id: int64(era_id)},
-		Genre:   db.Genre{Id: int64(genre_id)},
-		Kit:     db.Kit{Id: int64(kit_id)},
+		Id:       int64(id),
+		Title:    title,
+		Tempo:    tempo,
+		Click:    click,
+		KeyTone:  key_tone,
+		Vox:      db.Vox{Id: int64(vox_id)},
+		Era:      db.Era{Id: int64(era_id)},
+		Genre:    db.Genre{Id: int64(genre_id)},
+		Kit:      db.Kit{Id: int64(kit_id)},
+		Duration: duration,
 	}
 	err = v.db.UpdateTrack(t)
 	if err != nil {
@@ -506,14 +1003,71 @@ func (v *View) UpdateLyrics(w http.ResponseWriter, r *http.Request) {
 			io.WriteString(w, err.Error())
 		}
 	}
-	//fmt.Println(r.PostForm["lyrics"][0])
-	//m := NewMarkText(r.PostForm["lyrics"][0])
-
-	//	io.WriteString(w, "<html><body>"+m.PrettyText()+"</body></html>")
+	// saved either way, but go back to the form if the markup has problems
 	uri := fmt.Sprintf("/track/%d", id)
+	if len(lyrics.Diagnostics()) > 0 {
+		uri = fmt.Sprintf("/track/%d/edit#lyrics", id)
+	}
 	http.Redirect(w, r, uri, http.StatusFound)
 }
 
+// PreviewLyrics renders posted raw lyrics as an HTML fragment (diagnostics and table)
+// for the edit page, nothing is saved
+func (v *View) PreviewLyrics(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	rows, _ := strconv.Atoi(r.PostFormValue("rows_per_col"))
+	if rows < 0 {
+		rows = 0
+	}
+	m := db.NewMarkText(r.PostFormValue("lyrics"))
+	m.Expand = r.PostFormValue("expand") == "on"
+	err = v.index.ExecuteTemplate(w, "lyrics_preview.tmpl", struct {
+		Lyrics template.HTML
+		Diags  []db.Diagnostic
+	}{Lyrics: m.PrettyText(rows), Diags: m.Diagnostics()})
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+// ShowLyrics renders lyrics as plain text, JSON or ANSI (curl it from a terminal).
+// Add ?expand=1 to write repeated sections out.
+func (v *View) ShowLyrics(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Show Lyrics ", id, r.URL.Path)
+	t, err := v.db.GetTrack(int64(id))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	m := db.NewMarkText(t.Lyrics.RawText)
+	m.Expand = r.FormValue("expand") == "1"
+	switch path.Ext(r.URL.Path) {
+	case ".json":
+		js, err := m.JSON()
+		if err != nil {
+			io.WriteString(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	case ".ansi":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, m.ANSI())
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, m.PlainText())
+	}
+}
+
 func (v *View) ShowVoxes(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Show Voxes.")
 	voxes, err := v.db.GetAllVoxes()
@@ -686,294 +1240,6 @@ func (v *View) ShowKit(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (v *View) StartGig(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Start a gig")
-	setlists, err := v.db.GetAllSetlists()
-	if err != nil {
-		io.WriteString(w, err.Error())
-		return
-	}
-	err = v.index.ExecuteTemplate(w, "launch_gig.tmpl", setlists)
-	if err != nil {
-		io.WriteString(w, err.Error())
-	}
-}
-
-func (v *View) DoGig(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("DoGig.1: %s", err.Error()))
-		return
-	}
-	fmt.Println("Doin' gig", id)
-	// Load a gig - contains a list of tracks for each set? or point to a set and keep
-	// track of current song... temporary set?
-	// no form required. Just pass a parameter (hash) that references the information
-	// "pickle" a struct?
-	sl, err := v.db.GetSetlist(int64(id))
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("DoGig.2: %s", err.Error()))
-		return
-	}
-	gig, err := v.db.NewGig(sl)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("DoGig.3: %s", err.Error()))
-		return
-	}
-	//m := fmt.Sprintf("should be new gig: id=%d name=%s nsets=%d curset=%d", gig.Id, gig.ProperName(), len(gig.Sets), gig.CurSet)
-	//io.WriteString(w, m)
-	data := struct {
-		Id      int
-		Title   string
-		Name    string
-		SetName string
-		Lyrics  template.HTML
-		Tempo   int
-		KeyTone string
-	}{}
-	data.Id = int(gig.Id)
-	data.Name = gig.ProperName()
-	data.SetName = gig.Sets[gig.CurSet].ProperName()
-	tid := gig.Sets[gig.CurSet].Tracks[gig.CurTrack].Id
-	track, err := v.db.GetTrack(tid)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("DoGig.4: %s", err.Error()))
-		return
-	}
-	data.Lyrics = track.Lyrics.PrettyText(0)
-	data.Title = gig.Sets[gig.CurSet].Tracks[gig.CurTrack].ProperTitle()
-	data.Tempo = gig.Sets[gig.CurSet].Tracks[gig.CurTrack].Tempo
-	data.KeyTone = gig.Sets[gig.CurSet].Tracks[gig.CurSet].KeyTone
-	err = v.index.ExecuteTemplate(w, "gig.tmpl", data)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("DoGig.5: %s", err.Error()))
-		return
-	}
-}
-
-func (v *View) ShowGigNext(w http.ResponseWriter, r *http.Request) {
-	//fmt.Println("Show Next Song (ShowGigNext)")
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigNext.1: %s", err.Error()))
-		return
-	}
-	g, err := v.db.GetGig(int64(id))
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigNext.2: %s", err.Error()))
-		return
-	}
-	//fmt.Println("len(g.Sets)=", len(g.Sets))
-	//fmt.Println("g.CurTrack=", g.CurTrack)
-	//fmt.Println("g.CurSet=", g.CurSet)
-	//fmt.Println("TrackCount=", g.Sets[g.CurSet].TrackCount())
-	//fmt.Println("len(Tracks)=", len(g.Sets[g.CurSet].Tracks))
-	g.CurTrack += 1
-	if g.CurTrack >= g.Sets[g.CurSet].TrackCount() {
-		//fmt.Println("  that was the last track of set: ", g.CurSet)
-		data := struct {
-			Id      int64
-			Name    string
-			SetName string
-			Status  string
-		}{
-			Id:      g.Id,
-			Name:    g.ProperName(),
-			SetName: g.Sets[g.CurSet].ProperName(),
-			Status:  "End",
-		}
-
-		g.CurTrack = 0
-		g.CurSet += 1
-		if g.CurSet >= len(g.Sets) {
-			// done. Need to go back to setlist page?
-			//fmt.Println("Past the end, should show setlist_end.tmpl")
-			data := struct {
-				Id     int64
-				Name   string
-				Status string
-			}{
-				Id: g.Id,
-				//Name: g.ProperName(),
-				Name:   g.ProperName(),
-				Status: "End",
-			}
-			//fmt.Println("propername: ", g.ProperName())
-			// TODO: instead of forpin with the math, play a trick instead.
-			// pass the id for the NEXT set and track. It will look like the
-			// natural number index instead of 0-base
-			err = v.index.ExecuteTemplate(w, "setlist_end.tmpl", data)
-			if err != nil {
-				io.WriteString(w, fmt.Sprintf("ShowGigNext.7: %s", err.Error()))
-			}
-			return
-		} else {
-			g.CurTrack = -1 // next time through here it will get set incremented to the first track
-			err = v.db.UpdateGig(g)
-			if err != nil {
-				io.WriteString(w, fmt.Sprintf("ShowGigNext.8: %s", err.Error()))
-			}
-			err = v.index.ExecuteTemplate(w, "set_end.tmpl", data)
-			if err != nil {
-				io.WriteString(w, fmt.Sprintf("ShowGigNext.6: %s", err.Error()))
-			}
-			return
-		}
-	}
-	//fmt.Println("Next Track in set: ", g.CurTrack)
-	//fmt.Println("Next Set in Gig: ", g.CurSet)
-	err = v.db.UpdateGig(g)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigNext.3: %s", err.Error()))
-		return
-	}
-
-	// simplify template by only pulling what is needed
-	data := struct {
-		Id      int
-		Title   string
-		Name    string
-		SetName string
-		Lyrics  template.HTML
-		Tempo   int
-		KeyTone string
-	}{}
-	data.Id = int(g.Id)
-	data.Name = g.ProperName()
-	data.SetName = g.Sets[g.CurSet].ProperName()
-	tid := g.Sets[g.CurSet].Tracks[g.CurTrack].Id
-	//fmt.Println("TRACK ID=", tid)
-	track, err := v.db.GetTrack(tid)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigNext.4: %s", err.Error()))
-		return
-	}
-	data.Lyrics = track.Lyrics.PrettyText(0)
-	data.Title = g.Sets[g.CurSet].Tracks[g.CurTrack].ProperTitle()
-	data.Tempo = g.Sets[g.CurSet].Tracks[g.CurTrack].Tempo
-	data.KeyTone = g.Sets[g.CurSet].Tracks[g.CurTrack].KeyTone
-	err = v.index.ExecuteTemplate(w, "gig.tmpl", data)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigNext.5: %s", err.Error()))
-		return
-	}
-}
-
-func (v *View) ShowGigPrev(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Show Prev Song (ShowGigPrev)")
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigPrev.1: %s", err.Error()))
-		return
-	}
-	g, err := v.db.GetGig(int64(id))
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigPrev.2: %s", err.Error()))
-		return
-	}
-	fmt.Println("len(g.Sets)=", len(g.Sets))
-	fmt.Println("g.CurTrack=", g.CurTrack)
-	fmt.Println("g.CurSet=", g.CurSet)
-	fmt.Println("TrackCount=", g.Sets[g.CurSet].TrackCount())
-	fmt.Println("len(Tracks)=", len(g.Sets[g.CurSet].Tracks))
-	g.CurTrack -= 1
-	if g.CurTrack < 0 {
-		fmt.Println("  that was the first track of set: ", g.CurSet)
-		data := struct {
-			Id      int64
-			Name    string
-			SetName string
-			Status  string
-		}{
-			Id:      g.Id,
-			Name:    g.ProperName(),
-			SetName: g.Sets[g.CurSet].ProperName(),
-			Status:  "Beginning",
-		}
-		g.CurSet -= 1
-		if g.CurSet < 0 {
-			g.CurSet = 0
-			// done. stay on current?
-			data := struct {
-				Id     int64
-				Name   string
-				Status string
-			}{
-				Id:     g.Id,
-				Name:   g.ProperName(),
-				Status: "Beginning",
-			}
-			// TODO change to setlist_beg.tmpl or write the string via templ
-			err = v.index.ExecuteTemplate(w, "setlist_end.tmpl", data)
-			if err != nil {
-				io.WriteString(w, fmt.Sprintf("ShowGigNext.6: %s", err.Error()))
-			}
-			return
-		} else {
-			// should get subtracked next time through
-			g.CurTrack = len(g.Sets[g.CurSet].Tracks)
-			err = v.db.UpdateGig(g)
-			if err != nil {
-				io.WriteString(w, fmt.Sprintf("ShowGigNext.5: %s", err.Error()))
-			}
-			err = v.index.ExecuteTemplate(w, "set_end.tmpl", data)
-			if err != nil {
-				io.WriteString(w, fmt.Sprintf("ShowGigNext.7: %s", err.Error()))
-			}
-			return
-		}
-	}
-	fmt.Println("Next Track in set: ", g.CurTrack)
-	fmt.Println("Next Set in Gig: ", g.CurSet)
-	err = v.db.UpdateGig(g)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigNext.3: %s", err.Error()))
-		return
-	}
-
-	// simplify template by only pulling what is needed
-	data := struct {
-		Id      int
-		Title   string
-		Name    string
-		SetName string
-		Lyrics  template.HTML
-		Tempo   int
-		KeyTone string
-	}{}
-	data.Id = int(g.Id)
-	data.Name = g.ProperName()
-	data.SetName = g.Sets[g.CurSet].ProperName()
-	tid := g.Sets[g.CurSet].Tracks[g.CurTrack].Id
-	fmt.Println("TRACK ID=", tid)
-	track, err := v.db.GetTrack(tid)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigNext.4: %s", err.Error()))
-		return
-	}
-	data.Lyrics = track.Lyrics.PrettyText(0)
-	data.Title = g.Sets[g.CurSet].Tracks[g.CurTrack].ProperTitle()
-	data.Tempo = g.Sets[g.CurSet].Tracks[g.CurTrack].Tempo
-	data.KeyTone = g.Sets[g.CurSet].Tracks[g.CurTrack].KeyTone
-	err = v.index.ExecuteTemplate(w, "gig.tmpl", data)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("ShowGigNext.5: %s", err.Error()))
-		return
-	}
-}
-
-func (v *View) EndGig(w http.ResponseWriter, r *http.Request) {
-	// delete the current gig by id and redirect to the main page
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("EndGig.1: %s", err.Error()))
-		return
-	}
-	v.db.RemoveGig(int64(id))
-	url := "/"
-	http.Redirect(w, r, url, http.StatusFound)
-}
-
 /*
 func (v *View) ShowObj(w http.ResponseWriter, r *http.Request) {
 	s := r.PathValue("obj") + " id: " + r.PathValue("id")
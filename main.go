@@ -33,6 +33,12 @@ func main() {
 	case "serve":
 		// no params for now
 		serve()
+	case "lyrics":
+		if argc < 4 {
+			fmt.Printf("Need <dbfile> and <track id> to show lyrics.\n")
+			os.Exit(2)
+		}
+		showLyrics(os.Args[2], os.Args[3])
 	default:
 		fmt.Printf("Sorry. Dunno what you mean, \"%s\"....?\n", os.Args[1])
 		os.Exit(2)
@@ -65,12 +71,30 @@ func serve() {
 	if err := svr.ListenAndServe(); err != http.ErrServerClosed {
 		fmt.Println("Error ListenAndServe: ", err.Error())
 	}
-	// clean up the gigs...
-	fmt.Printf("Cleaning up...")
-	err = tdb.CleanupGigs()
+	// running gigs are left in the db, they're resumed next time
+}
+
+// print a track's lyrics with ANSI colors, for a terminal by the drum kit
+func showLyrics(dbfile, track string) {
+	id, err := strconv.Atoi(track)
 	if err != nil {
-		fmt.Println("Error cleaning up: ", err.Error())
+		fmt.Println("Not a track id: ", err.Error())
+		os.Exit(2)
+	}
+	tdb, err := db.OpenDB(dbfile)
+	if err != nil {
+		fmt.Println("Error opening track database: ", err.Error())
+		os.Exit(1)
+	}
+	t, err := tdb.GetTrack(int64(id))
+	if err != nil {
+		fmt.Println("Error getting track: ", err.Error())
+		os.Exit(1)
 	}
+	m := db.NewMarkText(t.Lyrics.RawText)
+	m.Expand = true
+	fmt.Println(t.ProperTitle(), "--", t.Tempo, "BPM")
+	fmt.Print(m.ANSI())
 }
 
 func flimport(infile, dbfile string) {
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"noodlizer/db"
+)
+
+// Flow rule handlers. Rules are added and deleted, there's nothing to edit.
+
+func (v *View) ShowRules(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Show Rules.")
+	rules, err := v.db.GetRules()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	genres, err := v.db.GetAllGenres()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	data := struct {
+		Rules  []db.Rule
+		Kinds  []db.RuleKind
+		Genres []db.Genre
+	}{rules, db.RuleKinds, genres}
+	err = v.index.ExecuteTemplate(w, "rules.tmpl", data)
+	if err != nil {
+		io.WriteString(w, err.Error())
+	}
+}
+
+func (v *View) SaveRule(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Saving (new) Rule")
+	err := r.ParseForm()
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	rule := db.Rule{Kind: r.PostFormValue("Kind")}
+	var kind *db.RuleKind
+	for i := range db.RuleKinds {
+		if db.RuleKinds[i].Kind == rule.Kind {
+			kind = &db.RuleKinds[i]
+		}
+	}
+	if kind == nil {
+		io.WriteString(w, fmt.Sprintf("no such rule %q", rule.Kind))
+		return
+	}
+	if kind.Value != "" {
+		rule.Value, err = strconv.Atoi(r.PostFormValue("Value"))
+		if err != nil || rule.Value < 1 {
+			io.WriteString(w, fmt.Sprintf("the rule needs a number of %s", kind.Value))
+			return
+		}
+	}
+	if kind.Genre {
+		rule.Genre.Id, _ = strconv.ParseInt(r.PostFormValue("Genre"), 10, 64)
+		if rule.Genre.Id == 0 {
+			io.WriteString(w, "the rule needs a genre")
+			return
+		}
+	}
+	_, err = v.db.AddRule(rule)
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/rules", http.StatusFound)
+}
+
+func (v *View) DelRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	fmt.Println("Deleting rule ", id)
+	err = v.db.DelRule(int64(id))
+	if err != nil {
+		io.WriteString(w, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/rules", http.StatusFound)
+}
+
+// setWarnings checks every set of the setlist against the rules, by set id
+func (v *View) setWarnings(sl db.Setlist) (map[int64][]db.FlowWarning, error) {
+	rules, err := v.db.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	warns := map[int64][]db.FlowWarning{}
+	for _, s := range sl.Sets {
+		warns[s.Id] = db.CheckSet(rules, s)
+	}
+	return warns, nil
+}
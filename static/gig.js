@@ -0,0 +1,27 @@
+// per device, per gig display toggles (chords for the guitarists, not the singer)
+addEventListener('load', (evt) => {
+    const info = document.getElementById('track-info')
+    const chords_btn = document.getElementById('chords')
+    if (info == null || chords_btn == null) {
+        return
+    }
+    const key = `chords-${info.dataset.gig}`
+
+    function apply(show) {
+        if (show) {
+            info.classList.remove('hide-chords')
+            chords_btn.textContent = 'HIDE CHORDS'
+        } else {
+            info.classList.add('hide-chords')
+            chords_btn.textContent = 'SHOW CHORDS'
+        }
+    }
+    apply(localStorage.getItem(key) == 'show')
+
+    chords_btn.onclick = (ev) => {
+        ev.preventDefault()
+        const show = info.classList.contains('hide-chords')
+        localStorage.setItem(key, show ? 'show' : 'hide')
+        apply(show)
+    }
+})
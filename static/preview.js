@@ -0,0 +1,36 @@
+// live preview of the lyrics markup on the track edit page
+(() => {
+    const raw = document.getElementById('lyrics-raw')
+    const rows = document.getElementById('rows-per-col')
+    const expand = document.getElementById('expand')
+    const preview = document.getElementById('lyrics-preview')
+    let timer
+
+    async function update() {
+        const body = new URLSearchParams()
+        body.append('lyrics', raw.value)
+        body.append('rows_per_col', rows.value)
+        if (expand.checked) {
+            body.append('expand', 'on')
+        }
+        try {
+            const resp = await fetch('/lyrics/preview', { method: 'POST', body: body })
+            if (resp.status != 200) {
+                throw new Error(`Unexpected HTTP Status ${resp.status} ${resp.statusText}`)
+            }
+            preview.innerHTML = await resp.text()
+        } catch (err) {
+            console.error(`preview failed: ${err.message}`)
+        }
+    }
+
+    // wait for a pause in the typing
+    function later() {
+        clearTimeout(timer)
+        timer = setTimeout(update, 300)
+    }
+    raw.addEventListener('input', later)
+    rows.addEventListener('input', later)
+    expand.addEventListener('change', update)
+    update()
+})()
@@ -26,6 +26,18 @@ onload = (evt) => {
                 case "proceed":
                     hideOverlay()
                     break
+                case "gig":
+                    // the leader moved, follow if this page is on that gig
+                    if (gig_state != null && msg.id == gig_state.dataset.gig && msg.pos != gig_state.dataset.pos) {
+                        location.replace(`/gig/${msg.id}`)
+                    }
+                    break
+                case "ended":
+                    // the gig was ended from another screen
+                    if (gig_state != null && msg.id == gig_state.dataset.gig) {
+                        location.replace('/')
+                    }
+                    break
                 default:
                     console.info("unexpected msg on ws: ", ev.data)
             }
@@ -33,6 +45,7 @@ onload = (evt) => {
     }
     dial()
 
+    const gig_state = document.getElementById('gig-state')
     const overlay = document.getElementById('overlay')
     const wait_btn = document.getElementById('wait')
     const ready_btn = document.getElementById('ready')
@@ -70,6 +70,21 @@ func (v *View) Subscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// catch a (re)connecting screen up with where the gigs are
+	v.ws_mtx.Lock()
+	pos := [][]byte{}
+	for _, msg := range v.gigpos {
+		pos = append(pos, msg)
+	}
+	v.ws_mtx.Unlock()
+	for _, msg := range pos {
+		err = v.writeTimeout(ctx, time.Second*1, c, msg)
+		if err != nil {
+			fmt.Println("ws.Write err: ", err.Error())
+			return
+		}
+	}
+
 	for {
 		select {
 		case msg := <-s.msgs: